@@ -0,0 +1,219 @@
+package jabot
+
+import (
+	"encoding/xml"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	nsCommands = "http://jabber.org/protocol/commands"
+	adhocTTL   = time.Minute * 10 // idle session expired
+)
+
+// AdhocSession XEP-0050 command session of multiple steps
+type AdhocSession struct {
+	ID     string
+	Node   string
+	From   string
+	Step   int                 // steps completed
+	Values map[string][]string // submitted values of all steps
+	Data   interface{}         // state of handler across steps
+	at     time.Time
+}
+
+// AdhocHandlerFunc type
+//	used for RegisterAdhoc, called with form submitted by previous step,
+//	nil for first step. Returns next form of type form to fill, or
+//	optional result form and note when completed
+type AdhocHandlerFunc func(s *AdhocSession, form *DataForm) (*DataForm,
+	string, error)
+
+type adhocCommand struct {
+	name string
+	fn   AdhocHandlerFunc
+}
+
+type commandXML struct {
+	Node      string    `xml:"node,attr"`
+	SessionID string    `xml:"sessionid,attr"`
+	Action    string    `xml:"action,attr"`
+	Form      *DataForm `xml:"jabber:x:data x"`
+}
+
+// RegisterAdhoc
+//	register XEP-0050 ad-hoc command node with form-aware handler,
+//	commands of RegisterHandle are exposed as well
+func (w *Jabot) RegisterAdhoc(node, name string, fn AdhocHandlerFunc) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.adhocs[node]; ok {
+		return errHandleExist
+	}
+	w.adhocs[node] = adhocCommand{name: name, fn: fn}
+	return nil
+}
+
+// adhocCommands returns ad-hoc commands by node, including handlers
+// of text commands
+func (w *Jabot) adhocCommands() map[string]adhocCommand {
+	res := map[string]adhocCommand{}
+	for cmd, cmdFunc := range handlers {
+		res[cmd] = adhocCommand{name: cmd, fn: w.textAdhoc(cmd, cmdFunc)}
+	}
+	w.mu.Lock()
+	for node, cmd := range w.adhocs {
+		res[node] = cmd
+	}
+	w.mu.Unlock()
+	return res
+}
+
+// adhocAllowed check jid may list and run ad-hoc commands, admins
+// and AdhocUsers only
+func (w *Jabot) adhocAllowed(jid string) bool {
+	return w.isAdmin(jid) || matchAny(w.cfg.AdhocUsers, getJid(jid))
+}
+
+// textAdhoc ad-hoc command of text command, asks comma separated args
+func (w *Jabot) textAdhoc(cmd string, cmdFunc CtxHandlerFunc) AdhocHandlerFunc {
+	return func(s *AdhocSession, form *DataForm) (*DataForm, string, error) {
+		if form == nil {
+			return &DataForm{Type: "form", Title: cmd,
+				Fields: []FormField{{Var: "args", Type: "text-single",
+					Label: "Arguments, comma separated"}}}, "", nil
+		}
+		text := cmd
+		if args := form.Value("args"); args != "" {
+			text += "," + args
+		}
+		ctx := w.newContext(&xmpp.Chat{Remote: s.From, Type: "chat",
+			Text: text})
+		defer w.release(ctx)
+		return nil, cmdFunc(ctx, strings.Split(text, ",")[1:]), nil
+	}
+}
+
+func adhocItemsXML(jid string, commands map[string]adhocCommand) string {
+	nodes := make([]string, 0, len(commands))
+	for node := range commands {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	res := "<query xmlns='" + nsDiscoItems + "' node='" + nsCommands + "'>"
+	for _, node := range nodes {
+		res += "<item jid='" + xmlEscape(jid) + "' node='" + xmlEscape(node) +
+			"' name='" + xmlEscape(commands[node].name) + "'/>"
+	}
+	return res + "</query>"
+}
+
+func adhocInfoXML(node, name string) string {
+	return "<query xmlns='" + nsDiscoInfo + "' node='" + xmlEscape(node) +
+		"'><identity category='automation' type='command-node' name='" +
+		xmlEscape(name) + "'/><feature var='" + nsCommands + "'/>" +
+		"<feature var='" + nsData + "'/></query>"
+}
+
+func (w *Jabot) iqCommand(iq *xmpp.IQ) (string, error) {
+	if iq.Type != "set" {
+		return "", ErrBadRequest
+	}
+	var req commandXML
+	if err := xml.Unmarshal(iq.Query, &req); err != nil {
+		return "", ErrBadRequest
+	}
+	// handlers may query others, reply out of Dail
+	go func(iq xmpp.IQ) {
+		body, err := w.execAdhoc(iq.From, &req)
+		if err != nil {
+			w.SendIQError(&iq, err)
+			return
+		}
+		if err := w.rawIQ(iq.To, iq.From, iq.ID, "result", body); err != nil {
+			log.Warning("ad-hoc reply", err)
+		}
+	}(*iq)
+	return "", errIQAsync
+}
+
+// execAdhoc execute step of ad-hoc command request from jid
+func (w *Jabot) execAdhoc(from string, req *commandXML) (string, error) {
+	if !w.adhocAllowed(from) {
+		return "", ErrForbidden
+	}
+	switch req.Action {
+	case "", "execute", "next", "complete", "cancel":
+	default:
+		return "", ErrBadRequest
+	}
+	now := time.Now()
+	var s *AdhocSession
+	w.mu.Lock()
+	for id, ss := range w.sessions {
+		if now.Sub(ss.at) > adhocTTL {
+			delete(w.sessions, id)
+		}
+	}
+	if req.SessionID != "" {
+		s = w.sessions[req.SessionID]
+		if s == nil || s.From != from || s.Node != req.Node {
+			// unknown, or step of the session still running
+			w.mu.Unlock()
+			return "", ErrBadRequest
+		}
+		// claimed by this step, put back if executing
+		delete(w.sessions, s.ID)
+	}
+	w.mu.Unlock()
+	if req.Action == "cancel" {
+		return "<command xmlns='" + nsCommands + "' node='" +
+			xmlEscape(req.Node) + "' sessionid='" + xmlEscape(req.SessionID) +
+			"' status='canceled'/>", nil
+	}
+	cmd, ok := w.adhocCommands()[req.Node]
+	if !ok {
+		return "", ErrItemNotFound
+	}
+	var form *DataForm
+	if s == nil {
+		s = &AdhocSession{ID: w.nextID("cmd"), Node: req.Node, From: from,
+			Values: map[string][]string{}}
+	} else {
+		form = req.Form
+		if form == nil {
+			form = &DataForm{Type: "submit"}
+		}
+		for _, ff := range form.Fields {
+			s.Values[ff.Var] = ff.Values
+		}
+	}
+	next, note, err := cmd.fn(s, form)
+	s.Step++
+	s.at = time.Now()
+	if err == nil && next != nil && next.Type == "form" {
+		w.mu.Lock()
+		w.sessions[s.ID] = s
+		w.mu.Unlock()
+	}
+	if err != nil {
+		return "", err
+	}
+	res := "<command xmlns='" + nsCommands + "' node='" + xmlEscape(s.Node) +
+		"' sessionid='" + s.ID + "' status='"
+	if next != nil && next.Type == "form" {
+		res += "executing'><actions execute='next'><next/></actions>"
+	} else {
+		res += "completed'>"
+	}
+	if note != "" {
+		res += "<note type='info'>" + xmlEscape(note) + "</note>"
+	}
+	if next != nil {
+		res += next.XML()
+	}
+	return res + "</command>", nil
+}
@@ -0,0 +1,151 @@
+package jabot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestAdhoc(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	w.cfg.AdhocUsers = []string{"bob@localhost", "eve@localhost"}
+	w.RegisterAdhoc("deploy", "Deploy service", func(s *AdhocSession,
+		form *DataForm) (*DataForm, string, error) {
+		switch s.Step {
+		case 0:
+			return &DataForm{Type: "form", Fields: []FormField{{
+					Var: "service", Type: "list-single", Required: true,
+					Options: []FormOption{{Value: "web"}, {Value: "db"}}}}},
+				"", nil
+		case 1:
+			return &DataForm{Type: "form", Fields: []FormField{{
+				Var: "confirm", Type: "boolean"}}}, "", nil
+		}
+		if form.Value("confirm") != "1" {
+			return nil, "not deployed", nil
+		}
+		return nil, "deployed " + s.Values["service"][0], nil
+	})
+	from := "bob@localhost/pc"
+	body, err := w.execAdhoc(from, &commandXML{Node: "deploy"})
+	if err != nil || !strings.Contains(body, "status='executing'") ||
+		!strings.Contains(body, "<required/><option><value>web</value>") {
+		t.Fatal("execAdhoc first step got", body, err)
+	}
+	var sid string
+	for id := range w.sessions {
+		sid = id
+	}
+	if _, err := w.execAdhoc("eve@localhost/pc", &commandXML{
+		Node: "deploy", SessionID: sid}); err != ErrBadRequest {
+		t.Error("execAdhoc session of other jid got", err)
+	}
+	body, err = w.execAdhoc(from, &commandXML{Node: "deploy",
+		SessionID: sid, Action: "next", Form: &DataForm{Type: "submit",
+			Fields: []FormField{{Var: "service", Values: []string{"web"}}}}})
+	if err != nil || !strings.Contains(body, "var='confirm'") {
+		t.Fatal("execAdhoc second step got", body, err)
+	}
+	body, err = w.execAdhoc(from, &commandXML{Node: "deploy",
+		SessionID: sid, Action: "complete", Form: &DataForm{Type: "submit",
+			Fields: []FormField{{Var: "confirm", Values: []string{"1"}}}}})
+	if err != nil || !strings.Contains(body, "status='completed'") ||
+		!strings.Contains(body, "<note type='info'>deployed web</note>") {
+		t.Error("execAdhoc last step got", body, err)
+	}
+	if len(w.sessions) != 0 {
+		t.Error("completed session kept")
+	}
+	if _, err := w.execAdhoc(from, &commandXML{Node: "none"}); err != ErrItemNotFound {
+		t.Error("execAdhoc unknown node got", err)
+	}
+}
+
+func TestAdhocItems(t *testing.T) {
+	s := adhocItemsXML("jabot@localhost/bot", map[string]adhocCommand{
+		"time": {name: "time"}, "deploy": {name: "Deploy"}})
+	if s != "<query xmlns='http://jabber.org/protocol/disco#items'"+
+		" node='http://jabber.org/protocol/commands'>"+
+		"<item jid='jabot@localhost/bot' node='deploy' name='Deploy'/>"+
+		"<item jid='jabot@localhost/bot' node='time' name='time'/></query>" {
+		t.Error("adhocItemsXML got", s)
+	}
+}
+
+func TestAdhocAccess(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	w.cfg.Subscription.Admins = []string{"boss@localhost"}
+	w.RegisterAdhoc("report", "Report", func(s *AdhocSession,
+		form *DataForm) (*DataForm, string, error) {
+		return nil, "done", nil
+	})
+	if _, err := w.execAdhoc("mallory@localhost/pc",
+		&commandXML{Node: "report"}); err != ErrForbidden {
+		t.Error("execAdhoc from stranger got", err)
+	}
+	if _, err := w.execAdhoc("mallory@localhost/pc",
+		&commandXML{Node: "time"}); err != ErrForbidden {
+		t.Error("text command from stranger got", err)
+	}
+	if body, err := w.execAdhoc("boss@localhost/pc",
+		&commandXML{Node: "report"}); err != nil ||
+		!strings.Contains(body, "status='completed'") {
+		t.Error("execAdhoc from admin got", body, err)
+	}
+	items := func(from string) string {
+		body, _ := w.iqDiscoItems(&xmpp.IQ{Type: "get", From: from,
+			Query: []byte("<query xmlns='" + nsDiscoItems + "' node='" +
+				nsCommands + "'/>")})
+		return body
+	}
+	if s := items("mallory@localhost/pc"); strings.Contains(s, "<item") {
+		t.Error("commands listed to stranger", s)
+	}
+	if s := items("boss@localhost/pc"); !strings.Contains(s, "node='report'") {
+		t.Error("commands not listed to admin", s)
+	}
+}
+
+func TestAdhocOverlap(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	entered := make(chan struct{})
+	proceed := make(chan struct{})
+	w.RegisterAdhoc("slow", "Slow", func(s *AdhocSession,
+		form *DataForm) (*DataForm, string, error) {
+		if s.Step == 0 {
+			return &DataForm{Type: "form"}, "", nil
+		}
+		close(entered)
+		<-proceed
+		return nil, "done", nil
+	})
+	from := cfg.Jid + "/pc"
+	if _, err := w.execAdhoc(from, &commandXML{Node: "slow"}); err != nil {
+		t.Fatal("execAdhoc first step", err)
+	}
+	var sid string
+	for id := range w.sessions {
+		sid = id
+	}
+	res := make(chan error, 1)
+	go func() {
+		_, err := w.execAdhoc(from, &commandXML{Node: "slow",
+			SessionID: sid, Action: "next"})
+		res <- err
+	}()
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Fatal("step not running")
+	}
+	if _, err := w.execAdhoc(from, &commandXML{Node: "slow",
+		SessionID: sid, Action: "next"}); err != ErrBadRequest {
+		t.Error("overlapping step got", err)
+	}
+	close(proceed)
+	if err := <-res; err != nil {
+		t.Error("running step got", err)
+	}
+}
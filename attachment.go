@@ -0,0 +1,269 @@
+package jabot
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	attachTTL = time.Minute * 10 // keep last attachment of peer
+	attachMax = 1000             // peers with attachment kept
+)
+
+var (
+	errAttachURL      = errors.New("Invalid attachment URL")
+	errAttachTooLarge = errors.New("Attachment too large")
+	errAttachType     = errors.New("Attachment content type not allowed")
+	errAttachAddr     = errors.New("Attachment host address not allowed")
+)
+
+// downloadClient for attachment download, only public addresses dialed,
+// replaced by tests
+var downloadClient = &http.Client{Timeout: time.Minute * 5,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: time.Second * 30,
+			Control: publicControl}).DialContext,
+		TLSHandshakeTimeout: time.Second * 30,
+	}}
+
+// privateNets not reachable by attachment download
+var privateNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12",
+		"192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		privateNets = append(privateNets, ipnet)
+	}
+}
+
+// publicControl reject dialing loopback, link-local, private or
+// unspecified address, so contacts can not make jabot request
+// internal hosts. Checked on the resolved address of every dial
+func publicControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() ||
+		ip.IsMulticast() {
+		return errAttachAddr
+	}
+	for _, ipnet := range privateNets {
+		if ipnet.Contains(ip) {
+			return errAttachAddr
+		}
+	}
+	return nil
+}
+
+// Attachment file shared by XEP-0066 out of band URL or aesgcm link
+type Attachment struct {
+	URL      string // as sent, aesgcm:// for encrypted
+	Desc     string
+	Received time.Time
+	iv, key  []byte // aesgcm
+}
+
+// Encrypted returns true for aesgcm attachment
+func (a *Attachment) Encrypted() bool {
+	return a.key != nil
+}
+
+type oobData struct {
+	URL  string `xml:"url"`
+	Desc string `xml:"desc"`
+}
+
+// parseAesgcm aesgcm://host/path#ivkey in hex, 12 or 16 bytes iv
+// and 32 bytes key
+func parseAesgcm(att *Attachment) bool {
+	a := strings.SplitN(att.URL, "#", 2)
+	if len(a) != 2 || (len(a[1]) != 88 && len(a[1]) != 96) {
+		return false
+	}
+	ivKey, err := hex.DecodeString(a[1])
+	if err != nil {
+		return false
+	}
+	att.iv, att.key = ivKey[:len(ivKey)-32], ivKey[len(ivKey)-32:]
+	return true
+}
+
+// parseAttachment returns attachment of message, nil if none
+func parseAttachment(m *xmpp.Chat) *Attachment {
+	var att *Attachment
+	for _, el := range m.OtherElem {
+		if el.XMLName.Space != nsOOB || el.XMLName.Local != "x" {
+			continue
+		}
+		var oob oobData
+		if err := xml.Unmarshal([]byte("<x>"+el.InnerXML+"</x>"),
+			&oob); err == nil && oob.URL != "" {
+			att = &Attachment{URL: strings.TrimSpace(oob.URL),
+				Desc: oob.Desc}
+		}
+		break
+	}
+	if body := strings.TrimSpace(m.Text); att == nil &&
+		strings.HasPrefix(body, "aesgcm://") && !strings.ContainsAny(body,
+		" \t\n") {
+		att = &Attachment{URL: body}
+	}
+	if att == nil {
+		return nil
+	}
+	if strings.HasPrefix(att.URL, "aesgcm://") && !parseAesgcm(att) {
+		return nil
+	}
+	att.Received = time.Now()
+	return att
+}
+
+// handleAttachment keep attachment of message for the peer,
+// true if message is the attachment only
+func (w *Jabot) handleAttachment(m *xmpp.Chat) bool {
+	att := parseAttachment(m)
+	if att == nil {
+		return false
+	}
+	log.Info("attachment from", m.Remote, att.URL)
+	w.mu.Lock()
+	if len(w.attachs) >= attachMax {
+		// drop expired, the oldest if none
+		var oldest string
+		for jid, a := range w.attachs {
+			if att.Received.Sub(a.Received) > attachTTL {
+				delete(w.attachs, jid)
+			} else if oldest == "" ||
+				a.Received.Before(w.attachs[oldest].Received) {
+				oldest = jid
+			}
+		}
+		if len(w.attachs) >= attachMax {
+			delete(w.attachs, oldest)
+		}
+	}
+	w.attachs[getJid(m.Remote)] = att
+	w.mu.Unlock()
+	return strings.TrimSpace(m.Text) == att.URL
+}
+
+// lastAttachment returns attachment received from jid within attachTTL
+func (w *Jabot) lastAttachment(jid string) *Attachment {
+	jid = getJid(jid)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	att, ok := w.attachs[jid]
+	if ok && time.Now().Sub(att.Received) > attachTTL {
+		delete(w.attachs, jid)
+		return nil
+	}
+	return att
+}
+
+// matchType check media type against allowed types, "image/" for
+// prefix match, all allowed if types is empty
+func matchType(mediaType string, types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, tt := range types {
+		if mediaType == tt ||
+			(strings.HasSuffix(tt, "/") && strings.HasPrefix(mediaType, tt)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Download
+//	download attachment up to maxSize bytes, DownloadMax of Config if
+//	maxSize is 0, aesgcm attachment decrypted. Content type checked
+//	against types like "text/plain" or "image/" if any,
+//	returns content and media type
+func (w *Jabot) Download(ctx context.Context, att *Attachment, maxSize int64,
+	types ...string) ([]byte, string, error) {
+	if maxSize <= 0 {
+		maxSize = w.cfg.DownloadMax
+	}
+	url := att.URL
+	if att.Encrypted() {
+		url = "https://" + strings.SplitN(url[len("aesgcm://"):], "#", 2)[0]
+	} else if !validURL(url) {
+		return nil, "", errAttachURL
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := downloadClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New("download: " + resp.Status)
+	}
+	if att.Encrypted() && maxSize > 0 {
+		// GCM tag appended
+		maxSize += 16
+	}
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		return nil, "", errAttachTooLarge
+	}
+	var r io.Reader = resp.Body
+	if maxSize > 0 {
+		r = io.LimitReader(resp.Body, maxSize+1)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, "", errAttachTooLarge
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if att.Encrypted() {
+		if data, err = decryptAesgcm(att, data); err != nil {
+			return nil, "", err
+		}
+		// server only sees ciphertext
+		mediaType = ""
+	}
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if !matchType(mediaType, types) {
+		return nil, mediaType, errAttachType
+	}
+	return data, mediaType, nil
+}
+
+func decryptAesgcm(att *Attachment, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(att.key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(att.iv))
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, att.iv, data, nil)
+}
@@ -0,0 +1,123 @@
+package jabot
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestParseAttachment(t *testing.T) {
+	m := xmpp.Chat{Remote: "bob@localhost/pc", Text: "https://a/b.log",
+		OtherElem: []xmpp.XMLElement{{
+			XMLName:  xml.Name{Space: nsOOB, Local: "x"},
+			InnerXML: "<url>https://a/b.log</url><desc>log</desc>"}}}
+	att := parseAttachment(&m)
+	if att == nil || att.URL != "https://a/b.log" || att.Desc != "log" ||
+		att.Encrypted() {
+		t.Error("parseAttachment oob got", att)
+	}
+	m = xmpp.Chat{Text: "aesgcm://a/b.jpg#" + strings.Repeat("0a", 44)}
+	if att = parseAttachment(&m); att == nil || !att.Encrypted() ||
+		len(att.iv) != 12 || len(att.key) != 32 {
+		t.Error("parseAttachment aesgcm got", att)
+	}
+	m = xmpp.Chat{Text: "aesgcm://a/b.jpg#0a"}
+	if att = parseAttachment(&m); att != nil {
+		t.Error("parseAttachment bad aesgcm got", att)
+	}
+	if att = parseAttachment(&xmpp.Chat{Text: "hi"}); att != nil {
+		t.Error("parseAttachment text got", att)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	plain := []byte("hello, jabot log\n")
+	key := make([]byte, 32)
+	iv := make([]byte, 12)
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	encrypted := gcm.Seal(nil, iv, plain, nil)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter,
+		req *http.Request) {
+		if req.URL.Path == "/enc" {
+			rw.Header().Set("Content-Type", "application/octet-stream")
+			rw.Write(encrypted)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.Write(plain)
+	}))
+	defer srv.Close()
+	oldClient := downloadClient
+	downloadClient = srv.Client()
+	defer func() { downloadClient = oldClient }()
+
+	w, _ := NewJabot(&cfg)
+	ctx := context.Background()
+	att := &Attachment{URL: srv.URL + "/a.log"}
+	data, mediaType, err := w.Download(ctx, att, 0, "text/")
+	if err != nil || string(data) != string(plain) || mediaType != "text/plain" {
+		t.Error("Download got", string(data), mediaType, err)
+	}
+	if _, _, err := w.Download(ctx, att, 5); err != errAttachTooLarge {
+		t.Error("Download over limit got", err)
+	}
+	if _, _, err := w.Download(ctx, att, 0, "image/"); err != errAttachType {
+		t.Error("Download wrong type got", err)
+	}
+	att = &Attachment{URL: "aesgcm://" + srv.URL[len("https://"):] + "/enc#" +
+		hex.EncodeToString(append(iv, key...))}
+	if !parseAesgcm(att) {
+		t.Fatal("parseAesgcm failed")
+	}
+	data, mediaType, err = w.Download(ctx, att, int64(len(plain)), "text/")
+	if err != nil || string(data) != string(plain) || mediaType != "text/plain" {
+		t.Error("Download aesgcm got", string(data), mediaType, err)
+	}
+	// no limit
+	w.cfg.DownloadMax = 0
+	data, _, err = w.Download(ctx, att, 0, "text/")
+	if err != nil || string(data) != string(plain) {
+		t.Error("Download aesgcm unlimited got", string(data), err)
+	}
+}
+
+func TestPublicControl(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:443", "[::1]:443",
+		"10.1.2.3:443", "172.20.0.1:443", "192.168.1.1:80",
+		"169.254.169.254:80", "[fe80::1]:443", "0.0.0.0:443",
+		"[fd00::1]:443"} {
+		if err := publicControl("tcp", addr, nil); err != errAttachAddr {
+			t.Error("dial allowed to", addr, err)
+		}
+	}
+	for _, addr := range []string{"93.184.216.34:443",
+		"[2606:2800:220:1::1]:443"} {
+		if err := publicControl("tcp", addr, nil); err != nil {
+			t.Error("dial denied to", addr, err)
+		}
+	}
+}
+
+func TestAttachMax(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	for i := 0; i < attachMax+10; i++ {
+		w.handleAttachment(&xmpp.Chat{Remote: "u" + strconv.Itoa(i) +
+			"@localhost/pc", Text: "https://a/b.log",
+			OtherElem: []xmpp.XMLElement{{
+				XMLName:  xml.Name{Space: nsOOB, Local: "x"},
+				InnerXML: "<url>https://a/b.log</url>"}}})
+	}
+	if n := len(w.attachs); n != attachMax {
+		t.Error("attachments not bounded", n)
+	}
+}
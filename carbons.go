@@ -0,0 +1,129 @@
+package jabot
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	nsCarbons = "urn:xmpp:carbons:2"
+	nsForward = "urn:xmpp:forward:0"
+)
+
+// origin of carbon copied message, Context.Carbon
+const (
+	CarbonSent     = "sent"     // sent by other client of our account
+	CarbonReceived = "received" // received by other client of our account
+)
+
+// CarbonHookFunc type
+//	used for RegisterCarbonHook, called with carbon copies of messages
+//	sent or received by other clients of our account, observe only
+type CarbonHookFunc func(origin, from, to, body string)
+
+// RegisterCarbonHook
+//	hook called with carbon copied messages
+func (w *Jabot) RegisterCarbonHook(hook CarbonHookFunc) {
+	w.carbonHook = hook
+}
+
+// carbonMessage message forwarded in carbon
+type carbonMessage struct {
+	From string `xml:"from,attr"`
+	To   string `xml:"to,attr"`
+	Type string `xml:"type,attr"`
+	ID   string `xml:"id,attr"`
+	Body string `xml:"body"`
+}
+
+type forwarded struct {
+	XMLName xml.Name      `xml:"urn:xmpp:forward:0 forwarded"`
+	Delay   *delayElem    `xml:"urn:xmpp:delay delay"`
+	Message carbonMessage `xml:"message"`
+}
+
+// enableCarbons enable XEP-0280 carbons for our resource
+func (w *Jabot) enableCarbons() error {
+	_, err := w.sendIQFunc("", "set", "<enable xmlns='"+nsCarbons+"'/>",
+		func(iq *xmpp.IQ) {
+			if err := iqError(iq); err != nil {
+				log.Warning("enable carbons", err)
+				return
+			}
+			log.Info("carbons enabled")
+		})
+	return err
+}
+
+// unwrapCarbon returns forwarded message and origin of carbon,
+// origin empty if not a carbon, message nil if carbon is invalid
+func (w *Jabot) unwrapCarbon(m *xmpp.Chat) (*carbonMessage, string) {
+	for _, el := range m.OtherElem {
+		if el.XMLName.Space != nsCarbons ||
+			(el.XMLName.Local != CarbonSent &&
+				el.XMLName.Local != CarbonReceived) {
+			continue
+		}
+		origin := el.XMLName.Local
+		if m.Remote != getJid(w.cfg.Jid) {
+			// only our own server forwards carbons
+			log.Warning("spoofed carbon from", m.Remote)
+			return nil, origin
+		}
+		var fwd forwarded
+		if err := xml.Unmarshal([]byte(el.InnerXML), &fwd); err != nil {
+			log.Warning("unmarshal carbon", err)
+			return nil, origin
+		}
+		return &fwd.Message, origin
+	}
+	return nil, ""
+}
+
+// handleCarbon process carbon copied message, commands sent by the owner
+// from other clients and replies go to the sending client. Received
+// carbons come from third parties, only passed to carbon hook
+func (w *Jabot) handleCarbon(c *carbonMessage, origin string) error {
+	content := strings.TrimSpace(c.Body)
+	if content == "" || c.Type == "groupchat" {
+		return nil
+	}
+	if w.carbonHook != nil {
+		w.carbonHook(origin, c.From, c.To, c.Body)
+	}
+	if origin != CarbonSent {
+		log.Info("[xC<] ", c.From, ": ", content)
+		return nil
+	}
+	if getJid(c.From) != getJid(w.cfg.Jid) {
+		log.Warning("sent carbon not from our account:", c.From)
+		return nil
+	}
+	if getJid(c.To) == getJid(w.cfg.Jid) {
+		// sent to ourself, handled as direct message
+		return nil
+	}
+	log.Info("[xC>] ", c.To, ": ", content)
+	switch content {
+	case "退下":
+		w.auto = false
+		return nil
+	case "来人":
+		w.auto = true
+		return nil
+	}
+	if reply, ok := w.adminCmd(c.From, content); ok {
+		_, err := w.SendMessage(reply, c.From)
+		return err
+	}
+	ctx := w.newContext(&xmpp.Chat{Remote: c.From, Type: c.Type, ID: c.ID,
+		Text: c.Body})
+	ctx.Carbon, ctx.To = origin, c.To
+	// reply goes to the owner's client, not the peer
+	if !w.runCommand(ctx, content, c.From) {
+		w.release(ctx)
+	}
+	return nil
+}
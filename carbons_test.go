@@ -0,0 +1,79 @@
+package jabot
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestUnwrapCarbon(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	sent := xmpp.XMLElement{
+		XMLName: xml.Name{Space: nsCarbons, Local: "sent"},
+		InnerXML: "<forwarded xmlns='urn:xmpp:forward:0'>" +
+			"<message xmlns='jabber:client' from='" + cfg.Jid + "/phone'" +
+			" to='bob@localhost/pc' type='chat' id='c1'>" +
+			"<body>time</body></message></forwarded>",
+	}
+	c, origin := w.unwrapCarbon(&xmpp.Chat{Remote: getJid(cfg.Jid),
+		OtherElem: []xmpp.XMLElement{sent}})
+	if origin != CarbonSent || c == nil {
+		t.Fatal("unwrap sent carbon got", origin, c)
+	}
+	if c.From != cfg.Jid+"/phone" || c.To != "bob@localhost/pc" ||
+		c.ID != "c1" || c.Body != "time" {
+		t.Error("carbon message got", *c)
+	}
+	c, origin = w.unwrapCarbon(&xmpp.Chat{Remote: "mallory@localhost",
+		OtherElem: []xmpp.XMLElement{sent}})
+	if origin != CarbonSent || c != nil {
+		t.Error("spoofed carbon accepted", origin, c)
+	}
+	if _, origin = w.unwrapCarbon(&xmpp.Chat{Remote: "bob@localhost",
+		Text: "hi"}); origin != "" {
+		t.Error("plain message as carbon", origin)
+	}
+}
+
+func TestHandleCarbon(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	if err := w.RegisterHandle("carbonping", func(args []string) string {
+		return "pong"
+	}); err != nil {
+		t.Fatal("RegisterHandle", err)
+	}
+	var hooked []string
+	w.RegisterCarbonHook(func(origin, from, to, body string) {
+		hooked = append(hooked, origin+" "+from)
+	})
+	sl, restore := captureStanzas(w)
+	defer restore()
+	w.handleCarbon(&carbonMessage{From: "mallory@localhost/pc",
+		To: cfg.Jid + "/phone", Type: "chat", Body: "carbonping"},
+		CarbonReceived)
+	// sent carbon must come from our own account
+	w.handleCarbon(&carbonMessage{From: "mallory@localhost/pc",
+		To: "bob@localhost/pc", Type: "chat", Body: "carbonping"},
+		CarbonSent)
+	time.Sleep(time.Millisecond * 50)
+	if stanzas := sl.all(); len(stanzas) != 0 {
+		t.Fatal("carbon from third party answered", stanzas)
+	}
+	if len(hooked) != 2 || hooked[0] != "received mallory@localhost/pc" {
+		t.Error("carbon hook got", hooked)
+	}
+	w.handleCarbon(&carbonMessage{From: cfg.Jid + "/phone",
+		To: "bob@localhost/pc", Type: "chat", Body: "carbonping"},
+		CarbonSent)
+	for i := 0; i < 100 && len(sl.all()) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if stanzas := sl.all(); len(stanzas) != 1 ||
+		!strings.Contains(stanzas[0], "to='"+cfg.Jid+"/phone'") ||
+		!strings.Contains(stanzas[0], "pong") {
+		t.Error("reply to owner got", stanzas)
+	}
+}
@@ -0,0 +1,78 @@
+package jabot
+
+import (
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	nsChatStates   = "http://jabber.org/protocol/chatstates"
+	composingDelay = time.Millisecond * 500
+)
+
+// XEP-0085 chat states
+const (
+	ChatStateActive    = "active"
+	ChatStateComposing = "composing"
+	ChatStatePaused    = "paused"
+	ChatStateInactive  = "inactive"
+	ChatStateGone      = "gone"
+)
+
+// ChatStateHookFunc type
+//	used for RegisterChatStateHook, called with chat state of peer
+type ChatStateHookFunc func(from, state string)
+
+// RegisterChatStateHook
+//	hook called when peer sends chat state, pending handlers of the peer
+//	are cancelled when it goes gone
+func (w *Jabot) RegisterChatStateHook(hook ChatStateHookFunc) {
+	w.stateHook = hook
+}
+
+func chatStateXML(state string) string {
+	return "<" + state + " xmlns='" + nsChatStates + "'/>"
+}
+
+// SendChatState send standalone chat state notification
+func (w *Jabot) SendChatState(to, state string) error {
+	_, err := w.Send(&OutMessage{To: to, Type: "chat",
+		Ext: []string{chatStateXML(state)}})
+	return err
+}
+
+// ChatState returns last chat state of peer, empty if none
+func (w *Jabot) ChatState(jid string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.peerStates[getJid(jid)]
+}
+
+// chatStateSupported check peer has sent chat states
+func (w *Jabot) chatStateSupported(jid string) bool {
+	return w.ChatState(jid) != ""
+}
+
+// handleChatState process chat state of incoming message
+func (w *Jabot) handleChatState(m *xmpp.Chat) {
+	if m.Type == "groupchat" {
+		return
+	}
+	for _, el := range m.OtherElem {
+		if el.XMLName.Space != nsChatStates {
+			continue
+		}
+		state := el.XMLName.Local
+		w.mu.Lock()
+		w.peerStates[getJid(m.Remote)] = state
+		w.mu.Unlock()
+		log.Info("chat state of", m.Remote, state)
+		if state == ChatStateGone {
+			w.cancelConv(m.Remote)
+		}
+		if w.stateHook != nil {
+			w.stateHook(m.Remote, state)
+		}
+	}
+}
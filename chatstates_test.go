@@ -0,0 +1,43 @@
+package jabot
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestChatState(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	var states []string
+	w.RegisterChatStateHook(func(from, state string) {
+		states = append(states, from+" "+state)
+	})
+	if w.chatStateSupported("bob@localhost") {
+		t.Error("chat state supported before any state")
+	}
+	ctx := w.newContext(&xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat",
+		Text: "time"})
+	w.handleChatState(&xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat",
+		OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: nsChatStates, Local: "composing"}}}})
+	if !w.chatStateSupported("bob@localhost/phone") {
+		t.Error("chat state not supported after composing")
+	}
+	if ctx.Err() != nil {
+		t.Error("context cancelled by composing")
+	}
+	w.handleChatState(&xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat",
+		OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: nsChatStates, Local: "gone"}}}})
+	if ctx.Err() == nil {
+		t.Error("context not cancelled by gone")
+	}
+	w.release(ctx)
+	if len(w.convs) != 0 {
+		t.Error("context not released")
+	}
+	if len(states) != 2 || states[1] != "bob@localhost/pc gone" {
+		t.Error("chat state hook got", states)
+	}
+}
@@ -6,6 +6,76 @@ type Config struct {
 	Passwd string
 	DefJid string
 	Domain string
+	// PingInterval seconds between keepalive pings, 0 to disable
+	PingInterval int `yaml:"pingInterval"`
+	// PingTimeout seconds to wait for pong
+	PingTimeout int `yaml:"pingTimeout"`
+	// Reconnect when connection lost
+	Reconnect bool `yaml:"reconnect"`
+	// Software advertised in jabber:iq:version
+	Software Software `yaml:"software"`
+	// LastContactsOnly refuse jabber:iq:last from non-contacts
+	LastContactsOnly bool `yaml:"lastContactsOnly"`
+	// VCardTTL seconds to cache vCard of contacts
+	VCardTTL int `yaml:"vcardTTL"`
+	// AvatarDir store avatars of contacts if not empty
+	AvatarDir string `yaml:"avatarDir"`
+	// Profile published as vCard and avatar at startup
+	Profile Profile `yaml:"profile"`
+	// Subscription policy for presence subscription requests
+	Subscription SubscriptionPolicy `yaml:"subscription"`
+	// RosterCache file to persist roster with XEP-0237 version
+	RosterCache string `yaml:"rosterCache"`
+	// Show of presence: "" for available, chat, away, xa or dnd
+	Show string `yaml:"show"`
+	// Status of presence, text/template with vars like {{.uptime}}
+	Status   string `yaml:"status"`
+	Priority int    `yaml:"priority"`
+	// StatusRefresh seconds to refresh status template, 0 to disable
+	StatusRefresh int `yaml:"statusRefresh"`
+	// Receipts request delivery receipts for SendMessage
+	Receipts bool `yaml:"receipts"`
+	// Carbons enable XEP-0280 message carbons, commands typed by the
+	// owner from other clients are handled
+	Carbons bool `yaml:"carbons"`
+	// Delayed policy for offline messages older than DelayedMaxAge
+	// minutes: ignore, execute or late to answer "seen late"
+	Delayed       string `yaml:"delayed"`
+	DelayedMaxAge int    `yaml:"delayedMaxAge"`
+	// UploadService jid of XEP-0363 service, discovered if empty
+	UploadService string `yaml:"uploadService"`
+	// DownloadMax default size limit of attachment download, 0 unlimited
+	DownloadMax int64 `yaml:"downloadMax"`
+	// Markdown format replies of handlers as XHTML-IM and XEP-0393
+	Markdown bool `yaml:"markdown"`
+	// MaxBody bytes of message body, longer messages split into numbered
+	// chunks, 0 to disable
+	MaxBody int `yaml:"maxBody"`
+	// MaxChunks more chunks uploaded as file if HTTP upload available,
+	// 0 never upload
+	MaxChunks int `yaml:"maxChunks"`
+	// ChunkInterval milliseconds between chunks to the same recipient
+	ChunkInterval int `yaml:"chunkInterval"`
+	// QueueSize of outgoing messages buffered while disconnected,
+	// 0 to write directly
+	QueueSize int `yaml:"queueSize"`
+	// QueueTTL seconds queued message kept, 0 forever
+	QueueTTL int `yaml:"queueTTL"`
+	// SendRetries of failed write
+	SendRetries int `yaml:"sendRetries"`
+	// SendRate and PeerRate messages per second, global and per
+	// recipient, 0 unlimited
+	SendRate float64 `yaml:"sendRate"`
+	PeerRate float64 `yaml:"peerRate"`
+	// AdhocUsers jid patterns like *@example.com allowed to run ad-hoc
+	// commands besides Subscription.Admins
+	AdhocUsers []string `yaml:"adhocUsers"`
+}
+
+type Software struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	ShowOS  bool   `yaml:"showOS"`
 }
 
 type Tuling struct {
@@ -20,7 +90,28 @@ func NewConfig(key string) Config {
 		key = "808811ad0fd34abaa6fe800b44a9556a"
 	}
 	var cfg = Config{Tuling: Tuling{URL: url, KeyAPI: key},
-		Jid: "test@localhost",
+		Jid:          "test@localhost",
+		PingInterval: 60,
+		PingTimeout:  30,
+		Reconnect:    true,
+		Software: Software{Name: "jabot/go-xmpp", Version: "0.1",
+			ShowOS: true},
+		VCardTTL:      86400,
+		Subscription:  SubscriptionPolicy{Mutual: true},
+		Show:          "xa",
+		Status:        "I'm gopher jabber",
+		StatusRefresh: 60,
+		Carbons:       true,
+		Delayed:       DelayedLate,
+		DelayedMaxAge: 5,
+		DownloadMax:   10 << 20,
+		MaxChunks:     5,
+		ChunkInterval: 1000,
+		QueueSize:     100,
+		QueueTTL:      300,
+		SendRetries:   3,
+		SendRate:      10,
+		PeerRate:      2,
 	}
 	return cfg
 }
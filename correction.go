@@ -0,0 +1,69 @@
+package jabot
+
+import (
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	nsCorrect  = "urn:xmpp:message-correct:0"
+	nsRetract  = "urn:xmpp:message-retract:1"
+	nsFallback = "urn:xmpp:fallback:0"
+	nsHints    = "urn:xmpp:hints"
+)
+
+// retractFallback body for clients without XEP-0424
+const retractFallback = "This person attempted to retract a previous " +
+	"message, but it's unsupported by your client."
+
+// EditMessage
+//	correct message id sent to by XEP-0308, id is always the first
+//	message even after corrections. Returns id of the correction
+func (w *Jabot) EditMessage(to, id, newText string) (string, error) {
+	return w.post(&OutMessage{To: to, Type: "chat", Body: newText,
+		Ext: []string{"<replace id='" + xmlEscape(id) + "' xmlns='" +
+			nsCorrect + "'/>"}})
+}
+
+// RetractMessage
+//	retract message id sent to by XEP-0424
+func (w *Jabot) RetractMessage(to, id string) (string, error) {
+	return w.post(&OutMessage{To: to, Type: "chat", Body: retractFallback,
+		Ext: []string{"<retract id='" + xmlEscape(id) + "' xmlns='" +
+			nsRetract + "'/>",
+			"<fallback xmlns='" + nsFallback + "' for='" + nsRetract + "'/>",
+			"<store xmlns='" + nsHints + "'/>"}})
+}
+
+// replaceID returns id of message corrected by m, empty if none
+func replaceID(m *xmpp.Chat) string {
+	for _, el := range m.OtherElem {
+		if el.XMLName.Space == nsCorrect && el.XMLName.Local == "replace" {
+			return elemAttr(&el, "id")
+		}
+	}
+	return ""
+}
+
+// cancelMessage cancel running handler of message id from the sender
+func (w *Jabot) cancelMessage(from, id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for c := range w.convs[getJid(from)] {
+		if c.Remote == from && (c.ID == id || c.Replace == id) {
+			log.Info("cancel handler of message", id, "from", from)
+			c.cancel()
+		}
+	}
+}
+
+// handleRetract cancel handler of retracted message, true if m is
+// a retraction
+func (w *Jabot) handleRetract(m *xmpp.Chat) bool {
+	for _, el := range m.OtherElem {
+		if el.XMLName.Space == nsRetract && el.XMLName.Local == "retract" {
+			w.cancelMessage(m.Remote, elemAttr(&el, "id"))
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package jabot
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestCorrection(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	orig := w.newContext(&xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat",
+		ID: "m1", Text: "tiem"})
+	replace := xmpp.XMLElement{XMLName: xml.Name{Space: nsCorrect,
+		Local: "replace"}, Attr: []xml.Attr{{Name: xml.Name{Local: "id"},
+		Value: "m1"}}}
+	other := w.newContext(&xmpp.Chat{Remote: "bob@localhost/phone",
+		Type: "chat", ID: "m2", Text: "time",
+		OtherElem: []xmpp.XMLElement{replace}})
+	if other.Replace != "m1" || orig.Err() != nil {
+		t.Error("correction from other resource cancelled original")
+	}
+	fixed := w.newContext(&xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat",
+		ID: "m3", Text: "time", OtherElem: []xmpp.XMLElement{replace}})
+	if fixed.Replace != "m1" || orig.Err() == nil {
+		t.Error("correction not cancelled original")
+	}
+	retract := xmpp.XMLElement{XMLName: xml.Name{Space: nsRetract,
+		Local: "retract"}, Attr: []xml.Attr{{Name: xml.Name{Local: "id"},
+		Value: "m1"}}}
+	if !w.handleRetract(&xmpp.Chat{Remote: "bob@localhost/pc",
+		OtherElem: []xmpp.XMLElement{retract}}) {
+		t.Error("retraction not handled")
+	}
+	if fixed.Err() == nil {
+		t.Error("retraction not cancelled correction")
+	}
+	if w.handleRetract(&xmpp.Chat{Remote: "bob@localhost/pc", Text: "hi"}) {
+		t.Error("plain message handled as retraction")
+	}
+}
@@ -0,0 +1,88 @@
+package jabot
+
+// DataForm XEP-0004 data form
+type DataForm struct {
+	Type         string      `xml:"type,attr"` // form, submit, cancel or result
+	Title        string      `xml:"title,omitempty"`
+	Instructions string      `xml:"instructions,omitempty"`
+	Fields       []FormField `xml:"field"`
+}
+
+// FormField field of data form, Type like text-single, text-multi,
+// boolean, list-single, jid-single or hidden
+type FormField struct {
+	Var      string       `xml:"var,attr,omitempty"`
+	Type     string       `xml:"type,attr,omitempty"`
+	Label    string       `xml:"label,attr,omitempty"`
+	Desc     string       `xml:"desc,omitempty"`
+	Required bool         `xml:"-"`
+	Values   []string     `xml:"value"`
+	Options  []FormOption `xml:"option"`
+}
+
+// FormOption option of list field
+type FormOption struct {
+	Label string `xml:"label,attr,omitempty"`
+	Value string `xml:"value"`
+}
+
+// Field returns field of var name, nil if not found
+func (f *DataForm) Field(name string) *FormField {
+	for i := range f.Fields {
+		if f.Fields[i].Var == name {
+			return &f.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Value returns first value of field name, empty if not found
+func (f *DataForm) Value(name string) string {
+	if ff := f.Field(name); ff != nil && len(ff.Values) > 0 {
+		return ff.Values[0]
+	}
+	return ""
+}
+
+// XML returns the <x xmlns='jabber:x:data'/> element
+func (f *DataForm) XML() string {
+	res := "<x xmlns='" + nsData + "' type='" + xmlEscape(f.Type) + "'>"
+	if f.Title != "" {
+		res += "<title>" + xmlEscape(f.Title) + "</title>"
+	}
+	if f.Instructions != "" {
+		res += "<instructions>" + xmlEscape(f.Instructions) +
+			"</instructions>"
+	}
+	for _, ff := range f.Fields {
+		res += "<field"
+		if ff.Var != "" {
+			res += " var='" + xmlEscape(ff.Var) + "'"
+		}
+		if ff.Type != "" {
+			res += " type='" + xmlEscape(ff.Type) + "'"
+		}
+		if ff.Label != "" {
+			res += " label='" + xmlEscape(ff.Label) + "'"
+		}
+		res += ">"
+		if ff.Desc != "" {
+			res += "<desc>" + xmlEscape(ff.Desc) + "</desc>"
+		}
+		if ff.Required {
+			res += "<required/>"
+		}
+		for _, v := range ff.Values {
+			res += "<value>" + xmlEscape(v) + "</value>"
+		}
+		for _, opt := range ff.Options {
+			res += "<option"
+			if opt.Label != "" {
+				res += " label='" + xmlEscape(opt.Label) + "'"
+			}
+			res += "><value>" + xmlEscape(opt.Value) + "</value></option>"
+		}
+		res += "</field>"
+	}
+	return res + "</x>"
+}
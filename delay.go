@@ -0,0 +1,77 @@
+package jabot
+
+import (
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const nsLegacyDelay = "jabber:x:delay"
+
+// Config.Delayed policies for stale delayed messages
+const (
+	DelayedIgnore  = "ignore"  // drop silently
+	DelayedExecute = "execute" // handle as new message
+	DelayedLate    = "late"    // answer "seen late", not executed
+)
+
+// delayStamp returns original send time of delayed message,
+// zero if not delayed
+func delayStamp(m *xmpp.Chat) time.Time {
+	if !m.Stamp.IsZero() {
+		return m.Stamp
+	}
+	for _, el := range m.OtherElem {
+		switch {
+		case el.XMLName.Space == nsDelay && el.XMLName.Local == "delay":
+			if tt, err := time.Parse(time.RFC3339,
+				elemAttr(&el, "stamp")); err == nil {
+				return tt
+			}
+		case el.XMLName.Space == nsLegacyDelay && el.XMLName.Local == "x":
+			// XEP-0091 CCYYMMDDThh:mm:ss in UTC
+			if tt, err := time.Parse("20060102T15:04:05",
+				elemAttr(&el, "stamp")); err == nil {
+				return tt
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// staleDelayed check delayed message older than DelayedMaxAge minutes
+func (w *Jabot) staleDelayed(stamp, now time.Time) bool {
+	if stamp.IsZero() {
+		return false
+	}
+	maxAge := time.Duration(w.cfg.DelayedMaxAge) * time.Minute
+	return now.Sub(stamp) > maxAge
+}
+
+// handleDelayed apply Delayed policy to stale delayed message,
+// true if the message is done and must not be executed
+func (w *Jabot) handleDelayed(m *xmpp.Chat) bool {
+	stamp := delayStamp(m)
+	if !w.staleDelayed(stamp, time.Now()) {
+		return false
+	}
+	sent := stamp.Local().Format("01-02 15:04:05")
+	switch w.cfg.Delayed {
+	case DelayedExecute:
+		log.Info("execute delayed message from", m.Remote, "sent", sent)
+		return false
+	case DelayedLate:
+		log.Info("seen late message from", m.Remote, "sent", sent)
+		if m.Type == "groupchat" {
+			// never answer room history
+			return true
+		}
+		if _, err := w.SendMessage("seen late: "+m.Text+" (sent "+sent+
+			"), not executed", m.Remote); err != nil {
+			log.Warning("answer delayed message", err)
+		}
+	default:
+		log.Info("ignore delayed message from", m.Remote, "sent", sent)
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package jabot
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestDelayStamp(t *testing.T) {
+	sent := time.Date(2018, 5, 1, 8, 0, 0, 0, time.UTC)
+	if tt := delayStamp(&xmpp.Chat{Stamp: sent}); !tt.Equal(sent) {
+		t.Error("delayStamp of Stamp got", tt)
+	}
+	m := xmpp.Chat{OtherElem: []xmpp.XMLElement{{
+		XMLName: xml.Name{Space: nsDelay, Local: "delay"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "stamp"},
+			Value: "2018-05-01T08:00:00Z"}}}}}
+	if tt := delayStamp(&m); !tt.Equal(sent) {
+		t.Error("delayStamp of delay got", tt)
+	}
+	m.OtherElem[0].XMLName = xml.Name{Space: nsLegacyDelay, Local: "x"}
+	m.OtherElem[0].Attr[0].Value = "20180501T08:00:00"
+	if tt := delayStamp(&m); !tt.Equal(sent) {
+		t.Error("delayStamp of legacy delay got", tt)
+	}
+	if tt := delayStamp(&xmpp.Chat{Text: "hi"}); !tt.IsZero() {
+		t.Error("delayStamp of new message got", tt)
+	}
+	w, _ := NewJabot(&cfg)
+	if w.staleDelayed(time.Time{}, sent) {
+		t.Error("new message is stale")
+	}
+	if w.staleDelayed(sent, sent.Add(time.Minute*4)) {
+		t.Error("message delayed 4 minutes is stale")
+	}
+	if !w.staleDelayed(sent, sent.Add(time.Hour)) {
+		t.Error("message delayed an hour is not stale")
+	}
+}
+
+func TestHandleDelayed(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	sl, restore := captureStanzas(w)
+	defer restore()
+	old := &xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat", Text: "ping",
+		Stamp: time.Now().Add(-time.Hour)}
+	if w.handleDelayed(&xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat",
+		Text: "ping", Stamp: time.Now().Add(-time.Minute)}) {
+		t.Error("fresh delayed message not executed")
+	}
+	w.cfg.Delayed = DelayedIgnore
+	if !w.handleDelayed(old) || len(sl.all()) != 0 {
+		t.Error("ignore policy got", sl.all())
+	}
+	w.cfg.Delayed = DelayedExecute
+	if w.handleDelayed(old) || len(sl.all()) != 0 {
+		t.Error("execute policy got", sl.all())
+	}
+	w.cfg.Delayed = DelayedLate
+	room := *old
+	room.Type = "groupchat"
+	if !w.handleDelayed(&room) || len(sl.all()) != 0 {
+		t.Error("late policy answered room history", sl.all())
+	}
+	if !w.handleDelayed(old) {
+		t.Error("late policy executed message")
+	}
+	if stanzas := sl.all(); len(stanzas) != 1 ||
+		!strings.Contains(stanzas[0], "to='bob@localhost/pc'") ||
+		!strings.Contains(stanzas[0], "seen late: ping (sent ") {
+		t.Error("seen late notification got", stanzas)
+	}
+}
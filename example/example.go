@@ -11,6 +11,7 @@ import (
 	"github.com/kjx98/jabot"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -248,8 +249,9 @@ func main() {
 								string(it.PhotoImg))
 							if err != nil {
 								fmt.Println("base64 decode:", err)
-							} else if fd, err := os.Create("/tmp/" + it.Name +
-								".png"); err == nil {
+							} else if fd, err := os.Create(filepath.Join(
+								os.TempDir(), jabot.AvatarFileName(v.From,
+									it.PhotoType))); err == nil {
 								fd.Write(pImg)
 								fd.Close()
 							}
@@ -0,0 +1,234 @@
+package jabot
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	nsXHTMLIM = "http://jabber.org/protocol/xhtml-im"
+	nsXHTML   = "http://www.w3.org/1999/xhtml"
+)
+
+var (
+	reInline = regexp.MustCompile("`([^`]+)`" +
+		`|\[([^\]]+)\]\((https?://[^)\s]+)\)` +
+		`|\*\*(.+?)\*\*` +
+		`|~~(.+?)~~` +
+		`|\*([^*\s][^*]*?)\*` +
+		`|\b_([^_\s][^_]*?)_\b` +
+		`|(https?://[^\s<>]+)`)
+	reHeading = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	reOrdered = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+	reRuler   = regexp.MustCompile(`^\|?[\s:|-]+\|?$`)
+)
+
+// formatInline convert inline markdown to XEP-0393 and XHTML
+func formatInline(s string) (string, string) {
+	var plain, html string
+	last := 0
+	for _, loc := range reInline.FindAllStringSubmatchIndex(s, -1) {
+		plain += s[last:loc[0]]
+		html += xmlEscape(s[last:loc[0]])
+		last = loc[1]
+		group := func(i int) string {
+			return s[loc[2*i]:loc[2*i+1]]
+		}
+		switch {
+		case loc[2] >= 0:
+			plain += "`" + group(1) + "`"
+			html += "<code>" + xmlEscape(group(1)) + "</code>"
+		case loc[4] >= 0:
+			text, url := group(2), group(3)
+			p, h := formatInline(text)
+			if text == url {
+				plain += url
+			} else {
+				plain += p + " <" + url + ">"
+			}
+			html += "<a href='" + xmlEscape(url) + "'>" + h + "</a>"
+		case loc[8] >= 0:
+			p, h := formatInline(group(4))
+			plain += "*" + p + "*"
+			html += "<strong>" + h + "</strong>"
+		case loc[10] >= 0:
+			p, h := formatInline(group(5))
+			plain += "~" + p + "~"
+			html += "<span style='text-decoration: line-through;'>" + h +
+				"</span>"
+		case loc[12] >= 0:
+			p, h := formatInline(group(6))
+			plain += "_" + p + "_"
+			html += "<em>" + h + "</em>"
+		case loc[14] >= 0:
+			p, h := formatInline(group(7))
+			plain += "_" + p + "_"
+			html += "<em>" + h + "</em>"
+		default:
+			url := group(8)
+			plain += url
+			html += "<a href='" + xmlEscape(url) + "'>" + xmlEscape(url) +
+				"</a>"
+		}
+	}
+	return plain + s[last:], html + xmlEscape(s[last:])
+}
+
+// tableCells split table row into trimmed cells
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// formatTable render table rows aligned for monospace font
+func formatTable(rows []string) string {
+	var table [][]string
+	var widths []int
+	for i, row := range rows {
+		if i == 1 && reRuler.MatchString(row) {
+			continue
+		}
+		cells := tableCells(row)
+		for j, cell := range cells {
+			if j == len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[j] {
+				widths[j] = n
+			}
+		}
+		table = append(table, cells)
+	}
+	var lines []string
+	for _, cells := range table {
+		line := ""
+		for j, cell := range cells {
+			if j < len(cells)-1 {
+				cell += strings.Repeat(" ",
+					widths[j]-utf8.RuneCountInString(cell)+2)
+			}
+			line += cell
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatMarkdown
+//	convert Markdown subset to XEP-0393 styled plain text and XHTML-IM
+//	body content: **bold**, *italic*, _italic_, ~~strike~~, `code`,
+//	code blocks, [links](url), headings, lists and tables
+func FormatMarkdown(md string) (string, string) {
+	var plain, html []string
+	var list string // open list tag of html
+	closeList := func() {
+		if list != "" {
+			html = append(html, "</"+list+">")
+			list = ""
+		}
+	}
+	openList := func(tag string) {
+		if list != tag {
+			closeList()
+			html = append(html, "<"+tag+">")
+			list = tag
+		}
+	}
+	lines := strings.Split(strings.Replace(md, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			closeList()
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			text := strings.Join(code, "\n")
+			plain = append(plain, "```\n"+text+"\n```")
+			html = append(html, "<pre>"+xmlEscape(text)+"</pre>")
+		case strings.HasPrefix(trimmed, "|"):
+			closeList()
+			rows := []string{trimmed}
+			for i+1 < len(lines) &&
+				strings.HasPrefix(strings.TrimSpace(lines[i+1]), "|") {
+				i++
+				rows = append(rows, strings.TrimSpace(lines[i]))
+			}
+			text := formatTable(rows)
+			plain = append(plain, "```\n"+text+"\n```")
+			html = append(html, "<pre>"+xmlEscape(text)+"</pre>")
+		case reHeading.MatchString(trimmed):
+			closeList()
+			a := reHeading.FindStringSubmatch(trimmed)
+			p, h := formatInline(a[2])
+			tag := "h" + strconv.Itoa(len(a[1]))
+			plain = append(plain, "*"+p+"*")
+			html = append(html, "<"+tag+">"+h+"</"+tag+">")
+		case strings.HasPrefix(trimmed, "- ") ||
+			strings.HasPrefix(trimmed, "* ") ||
+			strings.HasPrefix(trimmed, "+ "):
+			openList("ul")
+			p, h := formatInline(strings.TrimSpace(trimmed[2:]))
+			plain = append(plain, "• "+p)
+			html = append(html, "<li>"+h+"</li>")
+		case reOrdered.MatchString(trimmed):
+			openList("ol")
+			item := reOrdered.FindStringSubmatch(trimmed)[1]
+			p, h := formatInline(item)
+			plain = append(plain, trimmed[:len(trimmed)-len(item)]+p)
+			html = append(html, "<li>"+h+"</li>")
+		case trimmed == "":
+			closeList()
+			plain = append(plain, "")
+			html = append(html, "<br/>")
+		default:
+			closeList()
+			p, h := formatInline(line)
+			plain = append(plain, p)
+			html = append(html, h+"<br/>")
+		}
+	}
+	closeList()
+	// no trailing line break
+	res := strings.Join(html, "")
+	for strings.HasSuffix(res, "<br/>") {
+		res = strings.TrimSuffix(res, "<br/>")
+	}
+	return strings.TrimRight(strings.Join(plain, "\n"), "\n"), res
+}
+
+// xhtmlIM wrap XHTML body content as XEP-0071 extension
+func xhtmlIM(content string) string {
+	return "<html xmlns='" + nsXHTMLIM + "'><body xmlns='" + nsXHTML + "'>" +
+		content + "</body></html>"
+}
+
+// MarkdownMessage
+//	build chat message from Markdown, body is XEP-0393 styled plain text
+//	for clients without XHTML-IM
+func MarkdownMessage(to, md string) *OutMessage {
+	plain, html := FormatMarkdown(md)
+	m := &OutMessage{To: to, Type: "chat", Body: plain}
+	if html != strings.Replace(xmlEscape(plain), "&#xA;", "<br/>", -1) {
+		// formatted, not plain text
+		m.Ext = []string{xhtmlIM(html)}
+	}
+	return m
+}
+
+// SendMarkdown send message formatted from Markdown
+func (w *Jabot) SendMarkdown(to, md string) (string, error) {
+	return w.post(MarkdownMessage(to, md))
+}
@@ -0,0 +1,42 @@
+package jabot
+
+import (
+	"testing"
+)
+
+func TestFormatMarkdown(t *testing.T) {
+	tests := []struct {
+		md, plain, html string
+	}{
+		{"hello", "hello", "hello"},
+		{"**bold** and *it* `a<b`", "*bold* and _it_ `a<b`",
+			"<strong>bold</strong> and <em>it</em> <code>a&lt;b</code>"},
+		{"see [docs](https://a/b) or https://c/d",
+			"see docs <https://a/b> or https://c/d",
+			"see <a href='https://a/b'>docs</a> or " +
+				"<a href='https://c/d'>https://c/d</a>"},
+		{"# Title\n- one\n- ~~two~~", "*Title*\n• one\n• ~two~",
+			"<h1>Title</h1><ul><li>one</li><li><span style=" +
+				"'text-decoration: line-through;'>two</span></li></ul>"},
+		{"| name | qty |\n|---|---|\n| apple | 3 |",
+			"```\nname   qty\napple  3\n```",
+			"<pre>name   qty&#xA;apple  3</pre>"},
+		{"```\n**x**\n```", "```\n**x**\n```", "<pre>**x**</pre>"},
+	}
+	for _, tt := range tests {
+		plain, html := FormatMarkdown(tt.md)
+		if plain != tt.plain {
+			t.Errorf("FormatMarkdown(%q) plain got %q", tt.md, plain)
+		}
+		if html != tt.html {
+			t.Errorf("FormatMarkdown(%q) html got %q", tt.md, html)
+		}
+	}
+	if m := MarkdownMessage("bob@localhost", "line 1\nline 2"); len(m.Ext) != 0 {
+		t.Error("MarkdownMessage of plain text got", m.Ext)
+	}
+	if m := MarkdownMessage("bob@localhost", "**hi**"); len(m.Ext) != 1 ||
+		m.Body != "*hi*" {
+		t.Error("MarkdownMessage got", m.Body, m.Ext)
+	}
+}
@@ -0,0 +1,155 @@
+package jabot
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+// Context of incoming message for CtxHandlerFunc,
+// done when the peer goes gone or the handler returned
+type Context struct {
+	context.Context
+	Remote string // full jid of sender
+	Type   string // chat, groupchat or normal
+	ID     string
+	Text   string
+	To     string    // recipient of carbon copied message
+	Carbon string    // CarbonSent, empty if not carbon
+	Stamp  time.Time // original send time if delayed, zero if not
+	// Replace id of message corrected by this one, empty if not
+	Replace string
+	// Attachment of the message or sent by peer just before, nil if none
+	Attachment *Attachment
+	bot        *Jabot
+	cancel     context.CancelFunc
+}
+
+// CtxHandlerFunc type
+//	used for RegisterCtxHandle, as HandlerFunc with message context
+type CtxHandlerFunc func(ctx *Context, args []string) string
+
+// RegisterCtxHandle
+//	register command handler with message context, handlers run in own
+//	goroutine with chat state composing sent while running
+func (w *Jabot) RegisterCtxHandle(cmd string, cmdFunc CtxHandlerFunc) error {
+	return registerHandle(cmd, cmdFunc)
+}
+
+func (w *Jabot) newContext(m *xmpp.Chat) *Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Context{Context: ctx, Remote: m.Remote, Type: m.Type, ID: m.ID,
+		Text: m.Text, Stamp: delayStamp(m), Replace: replaceID(m),
+		bot: w, cancel: cancel}
+	c.Attachment = w.lastAttachment(m.Remote)
+	if c.Replace != "" {
+		// corrected command re-triggered, drop result of the original
+		w.cancelMessage(m.Remote, c.Replace)
+	}
+	jid := getJid(m.Remote)
+	w.mu.Lock()
+	if w.convs[jid] == nil {
+		w.convs[jid] = map[*Context]bool{}
+	}
+	w.convs[jid][c] = true
+	w.mu.Unlock()
+	return c
+}
+
+// release context of handled message
+func (w *Jabot) release(c *Context) {
+	c.cancel()
+	jid := getJid(c.Remote)
+	w.mu.Lock()
+	delete(w.convs[jid], c)
+	if len(w.convs[jid]) == 0 {
+		delete(w.convs, jid)
+	}
+	w.mu.Unlock()
+}
+
+// cancelConv cancel pending handlers of messages from jid
+func (w *Jabot) cancelConv(jid string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for c := range w.convs[getJid(jid)] {
+		c.cancel()
+	}
+}
+
+// runCommand run handler of command in content and reply to,
+// false if no handler for the command
+func (w *Jabot) runCommand(c *Context, content, to string) bool {
+	cmds := strings.Split(content, ",")
+	cmdFunc, ok := handlers[strings.ToLower(strings.Trim(cmds[0], " \t"))]
+	if !ok {
+		return false
+	}
+	go w.runHandler(c, to, func() (string, error) {
+		return cmdFunc(c, cmds[1:]), nil
+	})
+	return true
+}
+
+// runHandler run handler and send the reply to, composing sent to peer
+// supports chat states if handler is slow
+func (w *Jabot) runHandler(c *Context, to string,
+	handler func() (string, error)) {
+	defer w.release(c)
+	var composing bool
+	var timer *time.Timer
+	sent := make(chan struct{})
+	if to == c.Remote && w.chatStateSupported(to) {
+		timer = time.AfterFunc(composingDelay, func() {
+			w.SendChatState(to, ChatStateComposing)
+			close(sent)
+		})
+	}
+	reply, err := handler()
+	if timer != nil && !timer.Stop() {
+		// composing sent or being sent
+		<-sent
+		composing = true
+	}
+	if c.Err() != nil {
+		log.Info("peer gone, drop reply to", to)
+		return
+	}
+	if err != nil || reply == "" {
+		if err != nil {
+			log.Warning("handler", err)
+		}
+		if composing {
+			w.SendChatState(to, ChatStateActive)
+		}
+		return
+	}
+	if w.cfg.MaxBody > 0 && len(reply) > w.cfg.MaxBody {
+		if composing {
+			w.SendChatState(to, ChatStateActive)
+		}
+		if _, err := w.sendLong(reply, to, ""); err != nil {
+			log.Warning("send reply", err)
+		}
+		return
+	}
+	m := OutMessage{To: to, Type: "chat", Body: reply}
+	if w.cfg.Markdown {
+		m = *MarkdownMessage(to, reply)
+	}
+	if w.chatStateSupported(to) {
+		m.Ext = append(m.Ext, chatStateXML(ChatStateActive))
+	}
+	if w.cfg.Receipts {
+		m.ID = w.nextID("msg")
+		w.trackMessage(m.ID, to)
+		m.Ext = append(m.Ext, receiptExt()...)
+	}
+	if _, err := w.post(&m); err != nil {
+		log.Warning("send reply", err)
+		return
+	}
+	log.Info("[x#] ", w.nickName, ": ", reply)
+}
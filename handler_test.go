@@ -0,0 +1,80 @@
+package jabot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestRunHandler(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	ctx := w.newContext(&xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat",
+		ID: "m1", Text: "time"})
+	if len(w.convs["bob@localhost"]) != 1 {
+		t.Fatal("context not registered")
+	}
+	var got *Context
+	done := make(chan struct{})
+	go w.runHandler(ctx, ctx.Remote, func() (string, error) {
+		got = ctx
+		close(done)
+		return "", nil
+	})
+	<-done
+	if got != ctx || got.Text != "time" || got.ID != "m1" {
+		t.Error("handler context got", got)
+	}
+	n := 1
+	for i := 0; n != 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond * 10)
+		w.mu.Lock()
+		n = len(w.convs)
+		w.mu.Unlock()
+	}
+	if n != 0 || ctx.Err() == nil {
+		t.Error("context not released after handler returned")
+	}
+}
+
+func TestRegisterHandle(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	if err := w.RegisterHandle("Echo", func(args []string) string {
+		return args[0]
+	}); err != nil {
+		t.Fatal("RegisterHandle", err)
+	}
+	if err := w.RegisterCtxHandle("echo", func(ctx *Context,
+		args []string) string {
+		return ""
+	}); err != errHandleExist {
+		t.Error("RegisterCtxHandle of existing command got", err)
+	}
+	cmdFunc, ok := handlers["echo"]
+	if !ok || cmdFunc(nil, []string{"hi"}) != "hi" {
+		t.Error("HandlerFunc not wrapped")
+	}
+}
+
+func TestOwnerByJid(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	if err := w.RegisterHandle("ownerping", func(args []string) string {
+		return "pong"
+	}); err != nil {
+		t.Fatal("RegisterHandle", err)
+	}
+	w.nickName = "jabot"
+	w.updateContacts(&Contact{Jid: "bob@localhost", NickName: "jabot"})
+	sl, restore := captureStanzas(w)
+	defer restore()
+	w.handle(&xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat",
+		Text: "ownerping"})
+	for i := 0; i < 100 && len(sl.all()) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if stanzas := sl.all(); len(stanzas) != 1 ||
+		!strings.Contains(stanzas[0], "to='bob@localhost/pc'") {
+		t.Error("contact with owner nickname got", stanzas)
+	}
+}
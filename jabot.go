@@ -2,13 +2,12 @@ package jabot
 
 import (
 	"crypto/tls"
-	"encoding/base64"
-	"encoding/xml"
 	"errors"
 	"math/rand"
 	"os"
-	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kjx98/go-xmpp"
@@ -17,14 +16,56 @@ import (
 )
 
 type Jabot struct {
+	idSeq      uint64 // atomic, keep 64-bit aligned
 	cfg        Config
 	nickName   string
 	resource   string
+	idBase     string // random prefix of stanza id
 	client     *xmpp.Client
 	auto       bool
 	bConnected bool
 	lastAct    time.Time
+	cmu        sync.RWMutex // guard contacts and vcards
 	contacts   map[string]Contact
+	vcards     map[string]vcardEntry
+	subQueue   map[string]time.Time
+	subHook    SubscribeHookFunc
+	rcptHook   ReceiptHookFunc
+	stateHook  ChatStateHookFunc
+	tracks     map[string]*msgTrack
+	rosterVer  string
+	iqHandlers map[string]IQHandlerFunc
+	features   map[string]bool
+	mu         sync.Mutex
+	wmu        sync.Mutex // serialize writes to client
+	smu        sync.Mutex // guard client, bConnected and closed
+	pending    map[string]func(*xmpp.IQ)
+	convs      map[string]map[*Context]bool // running handlers by peer
+	peerStates map[string]string            // last chat state of peer
+	mamQuery   map[string]*mamCollect       // running archive queries
+	upload     *uploadService               // discovered HTTP upload
+	attachs    map[string]*Attachment       // last attachment of peer
+	chunkAt    map[string]time.Time         // last chunk sent to peer
+	outq       *sendQueue                   // nil if QueueSize is 0
+	sendHook   SendHookFunc
+	reactHook  ReactionHookFunc
+	carbonHook CarbonHookFunc
+	sentIDs    map[string]sentMsg          // bot messages for reactions
+	sentOrder  []string                    // sentIDs oldest first
+	reactWait  map[string][]chan *Reaction // WaitReaction by message id
+	adhocs     map[string]adhocCommand
+	sessions   map[string]*AdhocSession
+	rtt        time.Duration
+	startTime  time.Time
+	show       string
+	status     string // text/template with status vars
+	priority   int
+	statusVars map[string]func() string
+	lastStatus string  // last broadcast status text
+	avatarHash *string // XEP-0153 photo hash, nil for unknown
+	vcardSet   bool    // Profile published
+	done       chan struct{}
+	closed     bool
 }
 
 type Contact struct {
@@ -34,13 +75,10 @@ type Contact struct {
 	Group        []string
 	Online       bool
 	Subscription string
-}
-
-type vcardTemp struct {
-	Name      string `xml:"FN"`
-	NickName  string `xml:"NICKNAME"`
-	PhotoType string `xml:"PHOTO>TYPE"`
-	PhotoImg  []byte `xml:"PHOTO>BINVAL"`
+	Avatar       []byte // avatar image from vCard
+	AvatarType   string // MIME type of Avatar
+	Resources    map[string]Resource
+	LastSeen     time.Time
 }
 
 var (
@@ -48,15 +86,21 @@ var (
 	errLoginTimeout = errors.New("Login time out")
 	errNoConn       = errors.New("No connection to server")
 	errHandleExist  = errors.New("命令处理器已经存在")
+	errClosed       = errors.New("Jabot closed")
 )
 var log = logging.MustGetLogger("jabot")
 
+const (
+	reconnectDelay    = time.Second * 5
+	reconnectMaxDelay = time.Minute * 5
+)
+
 // HandleFunc type
 //	used for RegisterHandle
 type HandlerFunc func(args []string) string
 type HookFunc func(args string)
 
-var handlers = map[string]HandlerFunc{}
+var handlers = map[string]CtxHandlerFunc{}
 var hookName string
 var hookFunc HookFunc
 
@@ -67,9 +111,30 @@ func NewJabot(cfg *Config) (*Jabot, error) {
 	wx := Jabot{
 		cfg:      *cfg,
 		resource: "ebot-" + randID[2:12],
+		idBase:   strconv.FormatInt(rand.Int63(), 36),
 		contacts: make(map[string]Contact),
+		vcards:   make(map[string]vcardEntry),
+		subQueue: make(map[string]time.Time),
+		pending:  make(map[string]func(*xmpp.IQ)),
+		tracks:   make(map[string]*msgTrack),
 		auto:     true,
 	}
+	wx.cfg.Domain = getDomain(wx.cfg.Jid)
+	wx.convs = make(map[string]map[*Context]bool)
+	wx.peerStates = make(map[string]string)
+	wx.mamQuery = make(map[string]*mamCollect)
+	wx.attachs = make(map[string]*Attachment)
+	wx.chunkAt = make(map[string]time.Time)
+	wx.outq = newSendQueue(wx.cfg.QueueSize)
+	wx.sentIDs = make(map[string]sentMsg)
+	wx.reactWait = make(map[string][]chan *Reaction)
+	wx.adhocs = make(map[string]adhocCommand)
+	wx.sessions = make(map[string]*AdhocSession)
+	wx.registerDefaultIQ()
+	wx.initPresence()
+	if err := wx.loadRoster(); err != nil {
+		log.Warning("load roster cache", err)
+	}
 	return &wx, nil
 }
 
@@ -102,10 +167,6 @@ func (w *Jabot) SetLogLevel(l logging.Level) {
 	logging.SetLevel(l, "jabot")
 }
 
-func (w *Jabot) GetRoster() error {
-	return w.client.Roster()
-}
-
 func nickName(name string) string {
 	if a := strings.SplitN(name, "@", 2); len(a) == 2 {
 		return a[0]
@@ -128,16 +189,12 @@ func getDomain(addr string) string {
 }
 
 func (w *Jabot) Ping() error {
-	if !w.bConnected {
-		return errNoConn
-	}
-	return w.client.PingC2S(w.cfg.Jid, "")
+	_, err := w.PingRTT()
+	return err
 }
 
 func (w *Jabot) AddChat(jid string) error {
-	pr := xmpp.Presence{From: w.cfg.Jid, To: jid, Show: "xa"}
-	_, err := w.client.SendPresence(pr)
-	return err
+	return w.sendPresenceTo(jid, "", w.Show())
 }
 
 func (w *Jabot) updateContacts(contact *Contact) {
@@ -148,43 +205,93 @@ func (w *Jabot) updateContacts(contact *Contact) {
 			contact.NickName = nickName(contact.Jid)
 		}
 	}
+	w.cmu.Lock()
+	defer w.cmu.Unlock()
 	if cc, ok := w.contacts[contact.Jid]; ok {
 		// keep online status
 		contact.Online = cc.Online
+		contact.Resources = cc.Resources
+		contact.LastSeen = cc.LastSeen
 	}
 	w.contacts[contact.Jid] = *contact
 }
 
-func (w *Jabot) GetContacts() []Contact {
+func (w *Jabot) getContact(jid string) (Contact, bool) {
+	w.cmu.RLock()
+	defer w.cmu.RUnlock()
+	cc, ok := w.contacts[jid]
+	return cc, ok
+}
+
+// GetContacts
+//	returns contacts matching all filters, online contacts without filter
+func (w *Jabot) GetContacts(filters ...ContactFilter) []Contact {
+	if len(filters) == 0 {
+		filters = []ContactFilter{OnlineFilter}
+	}
+	w.cmu.RLock()
+	defer w.cmu.RUnlock()
 	res := []Contact{}
 	for _, cc := range w.contacts {
-		if cc.Online {
+		match := true
+		for _, filter := range filters {
+			if !filter(&cc) {
+				match = false
+				break
+			}
+		}
+		if match {
 			res = append(res, cc)
 		}
 	}
 	return res
 }
 
-func (w *Jabot) SendMessage(message string, to string) error {
-	if !w.bConnected {
-		return errNoConn
+// SendMessage
+//	send chat message, returns message id. Receipt requested if
+//	Config.Receipts, use WaitReceipt for delivery. Message longer than
+//	MaxBody split into chunks or uploaded as file in background, id is
+//	of the first chunk or the URL message. Queued if QueueSize,
+//	outcome reported by RegisterSendHook
+func (w *Jabot) SendMessage(message string, to string) (string, error) {
+	if w.cfg.MaxBody > 0 && len(message) > w.cfg.MaxBody {
+		// chunks are paced and upload waits for IQ results processed
+		// by Dail, never block the caller
+		id := w.nextID("msg")
+		go func() {
+			if _, err := w.sendLong(message, to, id); err != nil {
+				log.Warning("send long message", err)
+			}
+		}()
+		return id, nil
 	}
-	w.lastAct = time.Now()
-	chat := xmpp.Chat{Remote: to, Type: "chat", Text: message}
-	_, err := w.client.Send(chat)
-	return err
+	return w.sendChat("", message, to)
 }
 
-func (w *Jabot) SendGroupMessage(message string, to string) error {
-	if !w.bConnected {
-		return errNoConn
+// sendChat send chat message with id, generated if empty
+func (w *Jabot) sendChat(id, message, to string) (string, error) {
+	m := &OutMessage{To: to, Type: "chat", ID: id, Body: message}
+	if w.cfg.Receipts {
+		if m.ID == "" {
+			m.ID = w.nextID("msg")
+		}
+		w.trackMessage(m.ID, to)
+		m.Ext = receiptExt()
 	}
-	w.lastAct = time.Now()
-	chat := xmpp.Chat{Remote: to, Type: "groupchat", Text: message}
-	_, err := w.client.Send(chat)
-	return err
+	return w.post(m)
+}
+
+func (w *Jabot) SendGroupMessage(message string, to string) (string, error) {
+	return w.post(&OutMessage{To: to, Type: "groupchat", Body: message})
 }
+
 func (w *Jabot) RegisterHandle(cmd string, cmdFunc HandlerFunc) error {
+	return registerHandle(cmd, func(ctx *Context, args []string) string {
+		return cmdFunc(args)
+	})
+}
+
+func registerHandle(cmd string, cmdFunc CtxHandlerFunc) error {
 	cmd = strings.ToLower(cmd)
 	if _, ok := handlers[cmd]; ok {
 		return errHandleExist
@@ -196,7 +303,7 @@ func (w *Jabot) RegisterHandle(cmd string, cmdFunc HandlerFunc) error {
 func (w *Jabot) getNickName(userName string) string {
 	// strip resource
 	userName = getJid(userName)
-	if v, ok := w.contacts[userName]; ok {
+	if v, ok := w.getContact(userName); ok {
 		return v.NickName
 	}
 
@@ -207,10 +314,31 @@ func (w *Jabot) getNickName(userName string) string {
 }
 
 func (w *Jabot) handle(m *xmpp.Chat) error {
+	if w.handleArchived(m) {
+		return nil
+	}
+	if c, origin := w.unwrapCarbon(m); origin != "" {
+		if c == nil {
+			return nil
+		}
+		return w.handleCarbon(c, origin)
+	}
+	w.handleReceipts(m)
+	w.handleChatState(m)
+	if w.handleRetract(m) || w.handleReactions(m) {
+		return nil
+	}
 	content := strings.TrimSpace(m.Text)
 	if content == "" {
 		return nil
 	}
+	if w.handleDelayed(m) {
+		return nil
+	}
+	if w.handleAttachment(m) {
+		// file only, kept for following command
+		return nil
+	}
 	from := w.getNickName(m.Remote)
 	if hookName != "" {
 		log.Info("[xH*] ", from, ": ", m.Text)
@@ -220,33 +348,24 @@ func (w *Jabot) handle(m *xmpp.Chat) error {
 		}
 		return nil
 	}
-	if from != w.nickName {
+	if reply, ok := w.adminCmd(m.Remote, content); ok {
+		log.Info("[xA*] ", from, ": ", m.Text)
+		_, err := w.SendMessage(reply, m.Remote)
+		return err
+	}
+	// nickname is controlled by the contact, owner known by jid only
+	if getJid(m.Remote) != getJid(w.cfg.Jid) {
 		log.Info("[x*] ", from, ": ", m.Text)
-		cmds := strings.Split(content, ",")
-		if len(cmds) == 0 {
+		ctx := w.newContext(m)
+		if w.runCommand(ctx, content, m.Remote) {
 			return nil
 		}
-		cmds[0] = strings.ToLower(cmds[0])
-		if cmdFunc, ok := handlers[strings.Trim(cmds[0], " \t")]; ok {
-			reply := cmdFunc(cmds[1:])
-			if reply != "" {
-				if err := w.SendMessage(reply, m.Remote); err != nil {
-					return err
-				}
-				log.Info("[x#] ", w.getNickName(w.cfg.Jid), ": ", reply)
-			}
+		if w.auto {
+			go w.runHandler(ctx, m.Remote, func() (string, error) {
+				return w.getTulingReply(content, m.Remote)
+			})
 		} else {
-			if w.auto {
-				reply, err := w.getTulingReply(content, m.Remote)
-				if err != nil {
-					return err
-				}
-
-				if err := w.SendMessage(reply, m.Remote); err != nil {
-					return err
-				}
-				log.Info("[x#] ", w.nickName, ": ", reply)
-			}
+			w.release(ctx)
 		}
 	} else {
 		switch content {
@@ -256,20 +375,9 @@ func (w *Jabot) handle(m *xmpp.Chat) error {
 			w.auto = true
 		default:
 			log.Info("[x##] ", w.nickName, ": ", m.Text)
-			cmds := strings.Split(content, ",")
-			if len(cmds) == 0 {
-				return nil
-			}
-			cmds[0] = strings.ToLower(cmds[0])
-			if cmdFunc, ok := handlers[strings.Trim(cmds[0], " \t")]; ok {
-				reply := cmdFunc(cmds[1:])
-				if reply != "" {
-					if err := w.SendMessage(reply, w.cfg.DefJid); err != nil {
-						log.Warning("send myself to defGroup", err)
-						return err
-					}
-					log.Info("[x#] ", w.nickName, ": ", reply)
-				}
+			ctx := w.newContext(m)
+			if !w.runCommand(ctx, content, w.cfg.DefJid) {
+				w.release(ctx)
 			}
 		}
 	}
@@ -277,26 +385,50 @@ func (w *Jabot) handle(m *xmpp.Chat) error {
 }
 
 func (w *Jabot) Dail() error {
-	if err := w.dailLoop(0); err != nil {
-		w.bConnected = false
-		return err
+	for {
+		err := w.dailLoop(0)
+		if err == nil {
+			return nil
+		}
+		w.setConnected(false)
+		w.clearPresence()
+		if !w.cfg.Reconnect || w.isClosed() {
+			return err
+		}
+		log.Warning("connection lost:", err)
+		if err := w.reconnect(); err != nil {
+			return err
+		}
 	}
-	return nil
 }
 
-type rosterItem struct {
-	XMLName      xml.Name `xml:"item"`
-	Jid          string   `xml:"jid,attr"`
-	Name         string   `xml:"name,attr"`
-	Subscription string   `xml:"subscription,attr"`
-	Group        []string `xml:"group"`
+// reconnect with backoff until success or Close
+func (w *Jabot) reconnect() error {
+	delay := reconnectDelay
+	for !w.isClosed() {
+		time.Sleep(delay)
+		if err := w.Connect(); err != nil {
+			log.Warning("reconnect", err)
+		} else {
+			log.Info("reconnected to", w.cfg.Domain)
+			return nil
+		}
+		if delay *= 2; delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+	return errClosed
 }
 
 func (w *Jabot) dailLoop(timerCnt int) error {
 	endT := time.Now().Unix() + int64(timerCnt)
 	for timerCnt == 0 || endT > time.Now().Unix() {
 		// Recv, and process
-		if chat, err := w.client.Recv(); err != nil {
+		client := w.getClient()
+		if client == nil {
+			return errNoConn
+		}
+		if chat, err := client.Recv(); err != nil {
 			return err
 		} else {
 			switch v := chat.(type) {
@@ -307,191 +439,30 @@ func (w *Jabot) dailLoop(timerCnt int) error {
 					log.Warning("handle chat", err)
 				}
 			case xmpp.Presence:
-				if !w.auto {
-					break
-				}
 				switch v.Type {
 				case "subscribe":
-					if getDomain(v.From) != w.cfg.Domain {
-						log.Infof("Presence: %s subscription drop", v.From)
-						break
+					if w.auto {
+						w.handleSubscribe(v.From)
 					}
-					log.Infof("Presence: Approve %s subscription", v.From)
-					w.client.ApproveSubscription(v.From)
-					w.client.RequestSubscription(v.From)
 				case "unsubscribe":
-					log.Infof("Presence: Revoke %s subscription", v.From)
-					w.client.RevokeSubscription(v.From)
+					if w.auto {
+						log.Infof("Presence: Revoke %s subscription", v.From)
+						w.sendPresenceTo(v.From, "unsubscribed", "")
+					}
 				default:
-					jid := getJid(v.From)
-					if v.Type == "" {
+					// presence state tracked even if auto is off
+					w.updatePresence(&v)
+					if v.Type == "" && w.auto {
 						// query vcard
-						cc := w.contacts[jid]
-						//w.updateContacts(&cc)
-						if cc.Jid == "" {
-							cc.Jid = jid
-							cc.Name = w.getNickName(jid)
-						}
-						cc.Online = true
-						w.contacts[jid] = cc
-						if cc.Name == "" || cc.NickName == "" {
-							w.client.RawInformation(v.To, jid, "vc", "get",
-								"<vCard xmlns='vcard-temp'/>")
-						}
-					} else if v.Type == "unavailble" {
-						cc := w.contacts[jid]
-						if cc.Jid == jid {
-							cc.Online = false
-							w.contacts[jid] = cc
-						}
+						w.refreshVCard(getJid(v.From))
 					}
 					log.Infof("Presence: %s %s Type(%s)\n", v.From, v.Show, v.Type)
 				}
 			case xmpp.Roster, xmpp.Contact:
 				log.Info("Roster/Contact:", v)
 			case xmpp.IQ:
-				// ping ignore
-				var query xml.Name
-				if len(v.Query) > 0 && xml.Unmarshal(v.Query, &query) != nil {
-					log.Warning("xml.Unmarshal IQ", err)
-					continue
-				}
-				switch query.Space + " " + query.Local {
-				case "jabber:iq:version query":
-					if v.Type != "get" {
-						log.Info("type:", v.Type, " with:", string(v.Query))
-						continue
-					}
-					if err := w.RawVersion(v.To, v.From, v.ID, "0.1",
-						runtime.GOOS); err != nil {
-						log.Info("RawVersion:", err)
-					}
-					continue
-				case "jabber:iq:last query":
-					if v.Type != "get" {
-						log.Info("type:", v.Type, " with:", string(v.Query))
-						continue
-					}
-					tt := time.Now().Sub(w.lastAct)
-					last := int(tt.Seconds())
-					//if err := w.RawLastNA(v.To, v.From, v.ID); err != nil {
-					if err := w.RawLast(v.To, v.From, v.ID, last); err != nil {
-						log.Info("RawLast:", err)
-					}
-					continue
-				case "urn:xmpp:time time":
-					if v.Type != "get" {
-						log.Info("type:", v.Type, " with:", string(v.Query))
-						continue
-					}
-					if err := w.RawIQtime(v.To, v.From, v.ID); err != nil {
-						log.Info("RawIQtime:", err)
-					}
-					continue
-				case "jabber:iq:roster query":
-					type rosterItems struct {
-						Items []rosterItem `xml:"item"`
-					}
-					var roster rosterItems
-					if v.Type != "result" && v.Type != "set" {
-						// only result and set processed
-						log.Info("jabber:iq:roster, type:", v.Type)
-						continue
-					}
-					if err := xml.Unmarshal(v.Query, &roster); err != nil {
-						log.Error("unmarshal roster <query>: ", err)
-						continue
-					}
-					for _, item := range roster.Items {
-						cc, ok := w.contacts[item.Jid]
-						if !ok {
-							cc.Jid = item.Jid
-						}
-						if item.Name != "" {
-							cc.Name = item.Name
-						}
-						cc.Subscription = item.Subscription
-						cc.Group = item.Group
-						if item.Subscription == "remove" {
-							cc.Subscription = ""
-							if cc.Jid != "" {
-								w.updateContacts(&cc)
-							}
-							continue
-						}
-						if cc.Jid != "" {
-							w.updateContacts(&cc)
-							/*
-								// never query vcard here, may loops
-								if cc.Name == "" || cc.NickName == "" {
-									// try vCard
-								}
-							*/
-						}
-						if item.Subscription == "from" && cc.Online {
-							log.Infof("roster: Approve %s subscription", cc.Jid)
-							//w.client.ApproveSubscription(cc.Jid)
-							w.client.RequestSubscription(cc.Jid)
-						}
-						log.Infof("roster item %s subscription(%s), %v\n",
-							item.Jid, item.Subscription, item.Group)
-						if v.Type == "set" && item.Subscription == "both" {
-							// shall we check presence unavailable
-							pr := xmpp.Presence{From: v.To, To: item.Jid,
-								Show: "xa"}
-							w.client.SendPresence(pr)
-						}
-					}
-					continue
-				case "vcard-temp vCard":
-					var it vcardTemp
-					if err := xml.Unmarshal(v.Query, &it); err == nil {
-						jid := getJid(v.From)
-						if v.From == "" {
-							// vCard for me
-							jid = w.cfg.Jid
-							w.nickName = it.NickName
-							log.Info("Got nickName of myself:", it.NickName)
-						}
-						cc := w.contacts[jid]
-						if cc.Name == "" || cc.Jid == "" {
-							cc.Jid = jid
-							cc.Name = it.Name
-						}
-						if nicN := it.NickName; nicN != "" {
-							cc.NickName = nicN
-						} else {
-							cc.NickName = nickName(jid)
-						}
-						if it.Name != "" {
-							w.updateContacts(&cc)
-						}
-						pImg, err := base64.StdEncoding.DecodeString(
-							string(it.PhotoImg))
-						if err != nil {
-							log.Info("base64 decode:", err)
-						} else if it.PhotoType == "image/png" {
-							_ = pImg
-							/*
-								// PhotoImg is base64 []byte
-								if fd, err := os.Create("/tmp/" + jid + ".png"); err == nil {
-									fd.Write(pImg)
-									fd.Close()
-								}
-							*/
-						}
-						log.Infof("Got vCard for %s, FN:%s, Nick:%s/%s",
-							v.From, it.Name, it.NickName, cc.NickName)
-					} else {
-						log.Error("vcard-temp vCard", err)
-					}
-					continue
-				}
-				if v.Type == "result" && v.ID == "c2s1" {
-					log.Infof("Got pong from %s to %s\n", v.From, v.To)
-				} else {
-					log.Infof("Got from %s to %s IQ, tag: (%v), query(%s)\n",
-						v.From, v.To, query, string(v.Query))
+				if err := w.handleIQ(&v); err != nil {
+					log.Warning("handle IQ", err)
 				}
 			default:
 				log.Infof("def: %v\n", v)
@@ -506,33 +477,101 @@ func (w *Jabot) Connect() error {
 		Password:      w.cfg.Passwd,
 		NoTLS:         true,
 		Resource:      w.resource,
-		Status:        "xa",
-		StatusMessage: "I'm gopher jabber",
+		Status:        w.Show(),
+		StatusMessage: w.statusText(),
 	}
 	// now could comment out following Skip
 	xmpp.DefaultConfig = tls.Config{InsecureSkipVerify: true}
-	if talk, err := options.NewClient(); err != nil {
+	talk, err := options.NewClient()
+	if err != nil {
 		return err
-	} else {
-		if w.client != nil {
-			w.client.Close()
-		}
-		w.client = talk
-		w.bConnected = true
 	}
-	w.cfg.Domain = getDomain(w.cfg.Jid)
+	w.smu.Lock()
+	if w.closed {
+		// Close called while connecting
+		w.smu.Unlock()
+		talk.Close()
+		return errClosed
+	}
+	old := w.client
+	w.client = talk
+	w.bConnected = true
+	if w.done != nil {
+		close(w.done)
+	}
+	w.done = make(chan struct{})
+	done := w.done
+	w.smu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	w.mu.Lock()
+	w.pending = make(map[string]func(*xmpp.IQ))
 	w.lastAct = time.Now()
+	w.mu.Unlock()
+	go w.keepAlive(talk, done)
+	go w.statusLoop(done)
+	// presence of contacts resent by server after initial presence
+	w.clearPresence()
 	w.GetRoster()
+	if w.cfg.Carbons {
+		w.enableCarbons()
+	}
+	w.sendPresence()
+	if !w.vcardSet && w.cfg.Profile != (Profile{}) {
+		// response processed by Dail
+		w.vcardSet = true
+		go w.publishProfile()
+	}
 	return nil
 }
 
+// Close
+//	close connection and stop reconnecting, jabot can not connect again
 func (w *Jabot) Close() error {
-	if w.client == nil {
+	w.smu.Lock()
+	client := w.client
+	w.closed = true
+	w.bConnected = false
+	w.client = nil
+	if w.done != nil {
+		close(w.done)
+		w.done = nil
+	}
+	w.smu.Unlock()
+	if client == nil {
 		return errNoConn
 	}
-	w.client.Close()
-	w.client = nil
-	return nil
+	return client.Close()
+}
+
+// getClient returns current client, nil if closed
+func (w *Jabot) getClient() *xmpp.Client {
+	w.smu.Lock()
+	defer w.smu.Unlock()
+	return w.client
+}
+
+func (w *Jabot) setConnected(b bool) {
+	w.smu.Lock()
+	w.bConnected = b
+	w.smu.Unlock()
+}
+
+func (w *Jabot) isClosed() bool {
+	w.smu.Lock()
+	defer w.smu.Unlock()
+	return w.closed
+}
+
+// dropConn mark client lost and close it, Dail then reconnects
+func (w *Jabot) dropConn(client *xmpp.Client) {
+	w.smu.Lock()
+	if w.client == client {
+		w.bConnected = false
+	}
+	w.smu.Unlock()
+	client.Close()
 }
 
 func NewJabotConn(talk *xmpp.Client) *Jabot {
@@ -542,15 +581,36 @@ func NewJabotConn(talk *xmpp.Client) *Jabot {
 	wx := Jabot{
 		cfg:      NewConfig(""),
 		resource: "ebot" + randID[2:17],
+		idBase:   strconv.FormatInt(rand.Int63(), 36),
 		client:   talk,
 		contacts: make(map[string]Contact),
+		vcards:   make(map[string]vcardEntry),
+		subQueue: make(map[string]time.Time),
+		pending:  make(map[string]func(*xmpp.IQ)),
+		tracks:   make(map[string]*msgTrack),
 		auto:     true,
 	}
+	wx.cfg.Domain = getDomain(wx.cfg.Jid)
+	wx.convs = make(map[string]map[*Context]bool)
+	wx.peerStates = make(map[string]string)
+	wx.mamQuery = make(map[string]*mamCollect)
+	wx.attachs = make(map[string]*Attachment)
+	wx.chunkAt = make(map[string]time.Time)
+	wx.outq = newSendQueue(wx.cfg.QueueSize)
+	wx.sentIDs = make(map[string]sentMsg)
+	wx.reactWait = make(map[string][]chan *Reaction)
+	wx.adhocs = make(map[string]adhocCommand)
+	wx.sessions = make(map[string]*AdhocSession)
+	wx.cfg.Reconnect = false
+	wx.registerDefaultIQ()
+	wx.initPresence()
 	return &wx
 
 }
 
 func (w *Jabot) IsConnected() bool {
+	w.smu.Lock()
+	defer w.smu.Unlock()
 	return w.bConnected
 }
 
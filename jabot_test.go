@@ -1,11 +1,49 @@
 package jabot
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
 )
 
 var cfg = NewConfig("")
 
+// stanzaLog stanzas written by jabot under test
+type stanzaLog struct {
+	sync.Mutex
+	stanzas []string
+}
+
+func (sl *stanzaLog) all() []string {
+	sl.Lock()
+	defer sl.Unlock()
+	return append([]string(nil), sl.stanzas...)
+}
+
+// captureStanzas connect w to fake client recording written stanzas,
+// outgoing queue disabled, call the returned func to restore
+func captureStanzas(w *Jabot) (*stanzaLog, func()) {
+	sl := &stanzaLog{}
+	old := clientSend
+	clientSend = func(c *xmpp.Client, stanza string) error {
+		sl.Lock()
+		sl.stanzas = append(sl.stanzas, stanza)
+		sl.Unlock()
+		return nil
+	}
+	w.client = &xmpp.Client{}
+	w.bConnected = true
+	w.outq = nil
+	return sl, func() { clientSend = old }
+}
+
 func TestTuling(t *testing.T) {
 	w, err := NewJabot(&cfg)
 	if err != nil {
@@ -19,3 +57,279 @@ func TestTuling(t *testing.T) {
 		t.Log("got reply:", ss)
 	}
 }
+
+func TestRegisterIQHandler(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	appFunc := func(iq *xmpp.IQ) (string, error) {
+		return "", nil
+	}
+	if err := w.RegisterIQHandler("jabber:iq:version", "query",
+		appFunc); err == nil {
+		t.Error("RegisterIQHandler override builtin version handler")
+	}
+	if err := w.RegisterIQHandler("urn:example:app", "query",
+		appFunc); err != nil {
+		t.Error("RegisterIQHandler", err)
+	}
+	if err := w.RegisterIQHandler("urn:example:nil", "query",
+		nil); err != errIQHandlerNil {
+		t.Error("RegisterIQHandler nil handler got", err)
+	}
+	res := ErrServiceUnavailable.XML()
+	if res != "<error type='cancel'><service-unavailable "+
+		"xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/></error>" {
+		t.Error("StanzaError XML:", res)
+	}
+}
+
+func TestUnhandledIQ(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	sl, restore := captureStanzas(w)
+	defer restore()
+	for _, iqType := range []string{"get", "set", "result"} {
+		if err := w.handleIQ(&xmpp.IQ{ID: "q-" + iqType,
+			From: "bob@localhost/pc", To: cfg.Jid, Type: iqType,
+			Query: []byte("<query xmlns='urn:example:none'/>")}); err != nil {
+			t.Error("handleIQ", iqType, err)
+		}
+	}
+	stanzas := sl.all()
+	if len(stanzas) != 2 {
+		t.Fatal("replies to unhandled IQ got", stanzas)
+	}
+	for i, iqType := range []string{"get", "set"} {
+		if !strings.Contains(stanzas[i], "id='q-"+iqType+"' type='error'") ||
+			!strings.Contains(stanzas[i], "<service-unavailable") {
+			t.Error("reply to unhandled", iqType, "got", stanzas[i])
+		}
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	has := func(ns string) bool {
+		for _, f := range w.Features() {
+			if f == ns {
+				return true
+			}
+		}
+		return false
+	}
+	for _, ns := range []string{"jabber:iq:version", nsPing, nsDiscoInfo,
+		nsCommands, nsReceipts} {
+		if !has(ns) {
+			t.Error("feature not advertised:", ns)
+		}
+	}
+	for _, ns := range []string{"jabber:iq:roster", nsVCard} {
+		if has(ns) {
+			t.Error("client side namespace advertised:", ns)
+		}
+	}
+	if !has(nsCarbons) {
+		t.Error("carbons enabled but not advertised")
+	}
+	conf := NewConfig("")
+	conf.Carbons = false
+	if w2, _ := NewJabot(&conf); len(w2.Features()) != len(w.Features())-1 {
+		t.Error("carbons advertised when disabled", w2.Features())
+	}
+	sl, restore := captureStanzas(w)
+	defer restore()
+	ver := w.CapsVer()
+	if err := w.RegisterIQHandler("urn:example:app", "query",
+		func(iq *xmpp.IQ) (string, error) {
+			return "", nil
+		}); err != nil {
+		t.Fatal("RegisterIQHandler", err)
+	}
+	if !has("urn:example:app") || w.CapsVer() == ver {
+		t.Error("registered IQ namespace not advertised")
+	}
+	stanzas := sl.all()
+	if len(stanzas) != 1 || !strings.HasPrefix(stanzas[0], "<presence>") ||
+		!strings.Contains(stanzas[0], "ver='"+w.CapsVer()+"'") {
+		t.Error("presence with new caps not sent, got", stanzas)
+	}
+	w.RegisterFeature("urn:example:app")
+	if len(sl.all()) != 1 {
+		t.Error("presence re-sent for known feature")
+	}
+}
+
+func TestClose(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	// disconnected, waiting to reconnect
+	if err := w.Close(); err != errNoConn || !w.isClosed() {
+		t.Error("Close without client got", err, w.isClosed())
+	}
+	if err := w.reconnect(); err != errClosed {
+		t.Error("reconnect after Close got", err)
+	}
+	w, _ = NewJabot(&cfg)
+	w.client = &xmpp.Client{}
+	w.bConnected = true
+	w.done = make(chan struct{})
+	done := w.done
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Close()
+		}()
+	}
+	wg.Wait()
+	select {
+	case <-done:
+	default:
+		t.Error("done not closed")
+	}
+	if w.IsConnected() || w.getClient() != nil {
+		t.Error("connection state after Close")
+	}
+}
+
+func TestParseStanzaError(t *testing.T) {
+	data := []byte("<ping xmlns='urn:xmpp:ping'/><error type='cancel'>" +
+		"<service-unavailable xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/>" +
+		"<text xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'>no ping</text>" +
+		"</error>")
+	se := parseStanzaError(data)
+	if se == nil {
+		t.Error("parseStanzaError got nil")
+		return
+	}
+	if se.Type != "cancel" || se.Condition != "service-unavailable" ||
+		se.Text != "no ping" {
+		t.Error("parseStanzaError got", se)
+	}
+	if se := parseStanzaError([]byte("<query xmlns='jabber:iq:last'/>")); se != nil {
+		t.Error("parseStanzaError without error got", se)
+	}
+}
+
+func TestCapsVer(t *testing.T) {
+	// example from XEP-0115 5.2
+	features := []string{"http://jabber.org/protocol/caps",
+		"http://jabber.org/protocol/disco#info",
+		"http://jabber.org/protocol/disco#items",
+		"http://jabber.org/protocol/muc"}
+	if ver := capsVer("client", "pc", "Exodus 0.9.1", features); ver != "QgayPKawpkPSDYmwT/WM94uAlu0=" {
+		t.Error("capsVer got", ver)
+	}
+}
+
+func TestSubscribeAction(t *testing.T) {
+	conf := NewConfig("")
+	conf.Domain = "localhost"
+	w, _ := NewJabot(&conf)
+	if act := w.subscribeAction("bob@localhost"); act != SubscribeApprove {
+		t.Error("same domain got", act)
+	}
+	if act := w.subscribeAction("bob@example.com"); act != SubscribeDeny {
+		t.Error("other domain got", act)
+	}
+	w.cfg.Subscription = SubscriptionPolicy{Allow: []string{"*@example.com",
+		"partner.org"}, Deny: []string{"spam@example.com"}, Queue: true}
+	for jid, expect := range map[string]SubscribeAction{
+		"bob@example.com":       SubscribeApprove,
+		"Spam@example.com/res":  SubscribeDeny,
+		"alice@partner.org":     SubscribeApprove,
+		"alice@sub.partner.org": SubscribeQueue,
+		"bob@localhost":         SubscribeQueue,
+	} {
+		if act := w.subscribeAction(jid); act != expect {
+			t.Error("subscribeAction", jid, "got", act)
+		}
+	}
+	w.RegisterSubscribeHook(func(jid string) SubscribeAction {
+		if jid == "vip@localhost" {
+			return SubscribeApprove
+		}
+		return SubscribeDefault
+	})
+	if act := w.subscribeAction("vip@localhost"); act != SubscribeApprove {
+		t.Error("hook got", act)
+	}
+	if act := w.subscribeAction("spam@example.com"); act != SubscribeDeny {
+		t.Error("deny before hook got", act)
+	}
+	w.subQueue["bob@localhost"] = time.Now().Add(-time.Minute)
+	if reply, ok := w.subscriptionCmd("test@localhost/pc", "pending"); !ok ||
+		reply != "bob@localhost" {
+		t.Error("pending got", reply, ok)
+	}
+	if _, ok := w.subscriptionCmd("bob@localhost", "pending"); ok {
+		t.Error("pending from non admin")
+	}
+	w.subQueue["old@localhost"] = time.Now().Add(-subQueueTTL * 2)
+	if p := w.PendingSubscriptions(); len(p) != 1 {
+		t.Error("expired request pending", p)
+	}
+	if queued, _ := w.queueSubscribe("bob@localhost"); queued {
+		t.Error("repeated request queued again")
+	}
+	for i := 0; i < subQueueMax; i++ {
+		w.queueSubscribe("u" + strconv.Itoa(i) + "@localhost")
+	}
+	if n := len(w.PendingSubscriptions()); n != subQueueMax {
+		t.Error("subscription queue not capped", n)
+	}
+	if _, ok := w.subQueue["bob@localhost"]; ok {
+		t.Error("oldest request kept in full queue")
+	}
+}
+
+func TestRosterSetBody(t *testing.T) {
+	body := rosterSetBody(&rosterItem{Jid: "bob@localhost", Name: "Bob & co",
+		Group: []string{"ops", "oncall"}})
+	if body != "<query xmlns='jabber:iq:roster'><item jid='bob@localhost' "+
+		"name='Bob &amp; co'><group>ops</group><group>oncall</group></item>"+
+		"</query>" {
+		t.Error("rosterSetBody got", body)
+	}
+	w, _ := NewJabot(&cfg)
+	w.processRoster([]rosterItem{{Jid: "bob@localhost", Name: "Bob",
+		Subscription: "both", Group: []string{"ops"}}}, false)
+	if cc, ok := w.getContact("bob@localhost"); !ok || cc.Name != "Bob" ||
+		cc.NickName != "Bob" {
+		t.Error("processRoster got", cc)
+	}
+	w.processRoster([]rosterItem{{Jid: "bob@localhost",
+		Subscription: "remove"}}, false)
+	if _, ok := w.getContact("bob@localhost"); ok {
+		t.Error("processRoster remove failed")
+	}
+}
+
+func TestRosterCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jabot")
+	if err != nil {
+		t.Error("TempDir", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	conf := NewConfig("")
+	conf.RosterCache = filepath.Join(dir, "roster.json")
+	w, _ := NewJabot(&conf)
+	w.replaceRoster([]rosterItem{{Jid: "bob@localhost", Name: "Bob",
+		Subscription: "both"}, {Jid: "eve@localhost", Subscription: "to"},
+		{Jid: "carol@localhost"}})
+	w.saveRoster("v1")
+	w2, _ := NewJabot(&conf)
+	if w2.rosterVer != "v1" || len(w2.contacts) != 3 {
+		t.Error("loadRoster got", w2.rosterVer, w2.contacts)
+	}
+	if cc, _ := w2.getContact("carol@localhost"); cc.Subscription != "none" {
+		t.Error("roster item without subscription got", cc)
+	}
+	// full roster replaces cached contacts
+	w2.replaceRoster([]rosterItem{{Jid: "bob@localhost", Subscription: "both"}})
+	if _, ok := w2.getContact("eve@localhost"); ok {
+		t.Error("replaceRoster keep stale contact")
+	}
+	if cc, _ := w2.getContact("bob@localhost"); cc.Name != "Bob" {
+		t.Error("replaceRoster got", cc)
+	}
+}
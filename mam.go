@@ -0,0 +1,228 @@
+package jabot
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	nsMAM   = "urn:xmpp:mam:2"
+	nsRSM   = "http://jabber.org/protocol/rsm"
+	nsDelay = "urn:xmpp:delay"
+	nsData  = "jabber:x:data"
+)
+
+// MAMQuery XEP-0313 archive query, zero fields are not filtered
+type MAMQuery struct {
+	With  string // conversation peer, bare or full jid
+	Start time.Time
+	End   time.Time
+	Max   int    // page size, server default if 0
+	After string // archive id, page after it
+	// Before archive id, page before it. With Latest and empty Before
+	// returns the last page
+	Before string
+	Latest bool
+}
+
+// ArchivedMessage message of archive query result
+type ArchivedMessage struct {
+	ID    string // archive id, used for After/Before paging
+	MsgID string // id of the original message stanza
+	From  string
+	To    string
+	Type  string
+	Body  string
+	Stamp time.Time // archived time
+}
+
+// MAMResult page of archive query
+type MAMResult struct {
+	Messages []ArchivedMessage
+	First    string // archive id of first message in page
+	Last     string // archive id of last message in page
+	Count    int    // total number of messages if server tells
+	Complete bool   // no more pages in query direction
+}
+
+// delayElem XEP-0203 delayed delivery
+type delayElem struct {
+	From  string `xml:"from,attr"`
+	Stamp string `xml:"stamp,attr"`
+}
+
+type mamFin struct {
+	XMLName  xml.Name `xml:"urn:xmpp:mam:2 fin"`
+	Complete bool     `xml:"complete,attr"`
+	Set      struct {
+		First string `xml:"first"`
+		Last  string `xml:"last"`
+		Count int    `xml:"count"`
+	} `xml:"http://jabber.org/protocol/rsm set"`
+}
+
+// mamCollect collect result messages of running query
+type mamCollect struct {
+	archive string
+	res     MAMResult
+}
+
+func formField(name, value string) string {
+	return "<field var='" + name + "'><value>" + xmlEscape(value) +
+		"</value></field>"
+}
+
+func mamQueryXML(queryID string, q *MAMQuery) string {
+	res := "<query xmlns='" + nsMAM + "' queryid='" + queryID + "'>" +
+		"<x xmlns='" + nsData + "' type='submit'>" +
+		"<field var='FORM_TYPE' type='hidden'><value>" + nsMAM +
+		"</value></field>"
+	if q.With != "" {
+		res += formField("with", q.With)
+	}
+	if !q.Start.IsZero() {
+		res += formField("start", q.Start.UTC().Format(time.RFC3339))
+	}
+	if !q.End.IsZero() {
+		res += formField("end", q.End.UTC().Format(time.RFC3339))
+	}
+	res += "</x>"
+	if q.Max > 0 || q.After != "" || q.Before != "" || q.Latest {
+		res += "<set xmlns='" + nsRSM + "'>"
+		if q.Max > 0 {
+			res += "<max>" + strconv.Itoa(q.Max) + "</max>"
+		}
+		if q.After != "" {
+			res += "<after>" + xmlEscape(q.After) + "</after>"
+		}
+		if q.Before != "" {
+			res += "<before>" + xmlEscape(q.Before) + "</before>"
+		} else if q.Latest {
+			res += "<before/>"
+		}
+		res += "</set>"
+	}
+	return res + "</query>"
+}
+
+// QueryArchive
+//	query XEP-0313 archive of our account, or of room if archive is
+//	a room jid, returns a page of messages in chronological order.
+//	must not be called from handlers run by Dail
+func (w *Jabot) QueryArchive(ctx context.Context, archive string,
+	q *MAMQuery) (*MAMResult, error) {
+	queryID := w.nextID("mam")
+	coll := &mamCollect{archive: archive}
+	if archive == "" {
+		coll.archive = getJid(w.cfg.Jid)
+	}
+	w.mu.Lock()
+	w.mamQuery[queryID] = coll
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.mamQuery, queryID)
+		w.mu.Unlock()
+	}()
+	iq, err := w.SendIQ(ctx, archive, "set", mamQueryXML(queryID, q))
+	if err != nil {
+		return nil, err
+	}
+	var fin mamFin
+	if err := xml.Unmarshal(iq.Query, &fin); err != nil {
+		return nil, err
+	}
+	// results precede the fin, collected by Dail
+	w.mu.Lock()
+	res := coll.res
+	w.mu.Unlock()
+	res.First, res.Last = fin.Set.First, fin.Set.Last
+	res.Count, res.Complete = fin.Set.Count, fin.Complete
+	return &res, nil
+}
+
+// handleArchived collect archive result message, true if m is one
+func (w *Jabot) handleArchived(m *xmpp.Chat) bool {
+	for _, el := range m.OtherElem {
+		if el.XMLName.Space != nsMAM || el.XMLName.Local != "result" {
+			continue
+		}
+		w.mu.Lock()
+		coll, ok := w.mamQuery[elemAttr(&el, "queryid")]
+		w.mu.Unlock()
+		if !ok {
+			log.Warning("archive result of unknown query from", m.Remote)
+			return true
+		}
+		if m.Remote != "" && m.Remote != coll.archive {
+			log.Warning("spoofed archive result from", m.Remote)
+			return true
+		}
+		var fwd forwarded
+		if err := xml.Unmarshal([]byte(el.InnerXML), &fwd); err != nil {
+			log.Warning("unmarshal archive result", err)
+			return true
+		}
+		msg := ArchivedMessage{ID: elemAttr(&el, "id"),
+			MsgID: fwd.Message.ID, From: fwd.Message.From,
+			To: fwd.Message.To, Type: fwd.Message.Type,
+			Body: fwd.Message.Body}
+		if fwd.Delay != nil {
+			msg.Stamp, _ = time.Parse(time.RFC3339, fwd.Delay.Stamp)
+		}
+		w.mu.Lock()
+		coll.res.Messages = append(coll.res.Messages, msg)
+		w.mu.Unlock()
+		return true
+	}
+	return false
+}
+
+// RegisterHistoryCmd
+//	register history[,jid][,count] command, replies last messages with
+//	the sender from archive, jid of other conversation for admins only
+func (w *Jabot) RegisterHistoryCmd() {
+	w.RegisterCtxHandle("history", w.historyCmd)
+}
+
+func (w *Jabot) historyCmd(ctx *Context, args []string) string {
+	with, count := getJid(ctx.Remote), 10
+	if len(args) > 0 && strings.Contains(args[0], "@") {
+		if !w.isAdmin(ctx.Remote) {
+			return "history of other jid for admins only"
+		}
+		with = strings.TrimSpace(args[0])
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		n, err := strconv.Atoi(strings.TrimSpace(args[0]))
+		if err != nil || n <= 0 {
+			return "history[,jid][,count]"
+		}
+		if count = n; count > 50 {
+			count = 50
+		}
+	}
+	res, err := w.QueryArchive(ctx, "", &MAMQuery{With: with, Max: count,
+		Latest: true})
+	if err != nil {
+		return "history: " + err.Error()
+	}
+	var lines []string
+	for _, m := range res.Messages {
+		if m.Body == "" {
+			continue
+		}
+		lines = append(lines, m.Stamp.Local().Format("01-02 15:04:05")+" "+
+			w.getNickName(m.From)+": "+m.Body)
+	}
+	if len(lines) == 0 {
+		return "no history with " + with
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,59 @@
+package jabot
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestMAMQueryXML(t *testing.T) {
+	q := MAMQuery{With: "bob@localhost",
+		Start: time.Date(2018, 5, 1, 8, 0, 0, 0, time.UTC), Max: 5,
+		Latest: true}
+	if s := mamQueryXML("q1", &q); s != "<query xmlns='urn:xmpp:mam:2'"+
+		" queryid='q1'><x xmlns='jabber:x:data' type='submit'>"+
+		"<field var='FORM_TYPE' type='hidden'><value>urn:xmpp:mam:2"+
+		"</value></field><field var='with'><value>bob@localhost</value>"+
+		"</field><field var='start'><value>2018-05-01T08:00:00Z</value>"+
+		"</field></x><set xmlns='http://jabber.org/protocol/rsm'>"+
+		"<max>5</max><before/></set></query>" {
+		t.Error("mamQueryXML got", s)
+	}
+}
+
+func TestHandleArchived(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	coll := &mamCollect{archive: getJid(cfg.Jid)}
+	w.mamQuery["q1"] = coll
+	result := func(from string) *xmpp.Chat {
+		return &xmpp.Chat{Remote: from, OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: nsMAM, Local: "result"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "queryid"}, Value: "q1"},
+				{Name: xml.Name{Local: "id"}, Value: "a1"}},
+			InnerXML: "<forwarded xmlns='urn:xmpp:forward:0'>" +
+				"<delay xmlns='urn:xmpp:delay' stamp='2018-05-01T08:00:00Z'/>" +
+				"<message from='bob@localhost/pc' to='" + cfg.Jid + "'" +
+				" type='chat' id='m1'><body>time</body></message>" +
+				"</forwarded>"}}}
+	}
+	if !w.handleArchived(result(getJid(cfg.Jid))) {
+		t.Fatal("archive result not handled")
+	}
+	if !w.handleArchived(result("mallory@localhost")) {
+		t.Fatal("spoofed archive result not handled")
+	}
+	if w.handleArchived(&xmpp.Chat{Remote: "bob@localhost", Text: "hi"}) {
+		t.Error("plain message handled as archive result")
+	}
+	if len(coll.res.Messages) != 1 {
+		t.Fatal("archived messages got", coll.res.Messages)
+	}
+	m := coll.res.Messages[0]
+	if m.ID != "a1" || m.MsgID != "m1" || m.From != "bob@localhost/pc" ||
+		m.Body != "time" ||
+		!m.Stamp.Equal(time.Date(2018, 5, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Error("archived message got", m)
+	}
+}
@@ -0,0 +1,97 @@
+package jabot
+
+import (
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+// clientSend write raw stanza to client, replaced by tests
+var clientSend = func(c *xmpp.Client, stanza string) error {
+	_, err := c.SendOrg(stanza)
+	return err
+}
+
+// sendOrg write raw stanza, writes from handlers, keepalive and Dail
+// are serialized
+func (w *Jabot) sendOrg(stanza string) error {
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+	client := w.getClient()
+	if client == nil {
+		return errNoConn
+	}
+	return clientSend(client, stanza)
+}
+
+// rawIQ write IQ stanza with payload body
+func (w *Jabot) rawIQ(from, to, id, iqType, body string) error {
+	stanza := "<iq"
+	if from != "" {
+		stanza += " from='" + xmlEscape(from) + "'"
+	}
+	if to != "" {
+		stanza += " to='" + xmlEscape(to) + "'"
+	}
+	return w.sendOrg(stanza + " id='" + xmlEscape(id) + "' type='" + iqType +
+		"'>" + body + "</iq>")
+}
+
+// sendPresenceTo write directed presence, show for available or type
+// like subscribe, subscribed, unsubscribed
+func (w *Jabot) sendPresenceTo(to, presType, show string) error {
+	stanza := "<presence to='" + xmlEscape(to) + "'"
+	if presType != "" {
+		stanza += " type='" + presType + "'"
+	}
+	if show == "" {
+		return w.sendOrg(stanza + "/>")
+	}
+	return w.sendOrg(stanza + "><show>" + show + "</show></presence>")
+}
+
+// OutMessage outgoing message stanza
+type OutMessage struct {
+	To   string
+	Type string // chat, groupchat, normal or headline
+	ID   string // generated if empty
+	Body string
+	Ext  []string // extension elements in raw XML
+}
+
+// XML returns the <message/> stanza
+func (m *OutMessage) XML() string {
+	res := "<message to='" + xmlEscape(m.To) + "' type='" + m.Type +
+		"' id='" + xmlEscape(m.ID) + "'>"
+	if m.Body != "" {
+		res += "<body>" + xmlEscape(m.Body) + "</body>"
+	}
+	for _, ext := range m.Ext {
+		res += ext
+	}
+	return res + "</message>"
+}
+
+// Send
+//	write message stanza, type chat and unique id filled if empty,
+//	returns id of the message. Written directly, not queued nor rate
+//	limited, used for receipts, markers and chat states
+func (w *Jabot) Send(m *OutMessage) (string, error) {
+	if !w.IsConnected() {
+		return "", errNoConn
+	}
+	if m.ID == "" {
+		m.ID = w.nextID("msg")
+	}
+	if m.Type == "" {
+		m.Type = "chat"
+	}
+	w.mu.Lock()
+	w.lastAct = time.Now()
+	w.mu.Unlock()
+	if m.Body != "" {
+		w.sentMessage(m.ID, m.To)
+	}
+	err := w.sendOrg(m.XML())
+	return m.ID, err
+}
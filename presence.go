@@ -0,0 +1,343 @@
+package jabot
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+// Resource presence of contact's resource
+type Resource struct {
+	Show     string // "" for available, chat, away, xa or dnd
+	Status   string
+	Priority int
+	LastSeen time.Time
+	Caps     string // XEP-0115 node#ver of client
+}
+
+// ContactFilter type
+//	used for GetContacts, select contacts matching the filter
+type ContactFilter func(cc *Contact) bool
+
+// showRank rank of presence show, higher is more available
+func showRank(show string) int {
+	switch show {
+	case "chat":
+		return 5
+	case "":
+		return 4
+	case "away":
+		return 3
+	case "xa":
+		return 2
+	case "dnd":
+		return 1
+	}
+	return 0
+}
+
+// Best returns resource of contact with best presence,
+// highest priority first then most available show and latest seen
+func (cc *Contact) Best() (string, Resource, bool) {
+	var (
+		best    string
+		bestRes Resource
+		found   bool
+	)
+	for name, res := range cc.Resources {
+		if !found {
+			best, bestRes, found = name, res, true
+			continue
+		}
+		if res.Priority != bestRes.Priority {
+			if res.Priority > bestRes.Priority {
+				best, bestRes = name, res
+			}
+			continue
+		}
+		if rk, bk := showRank(res.Show), showRank(bestRes.Show); rk != bk {
+			if rk > bk {
+				best, bestRes = name, res
+			}
+			continue
+		}
+		if res.LastSeen.After(bestRes.LastSeen) {
+			best, bestRes = name, res
+		}
+	}
+	return best, bestRes, found
+}
+
+// Show returns show of best presence, "unavailable" if offline
+func (cc *Contact) Show() string {
+	if _, res, ok := cc.Best(); ok {
+		return res.Show
+	}
+	return "unavailable"
+}
+
+// OnlineFilter select contacts with any available resource
+func OnlineFilter(cc *Contact) bool {
+	return cc.Online
+}
+
+// AvailableFilter select online contacts not away, xa or dnd
+func AvailableFilter(cc *Contact) bool {
+	return cc.Online && showRank(cc.Show()) >= showRank("")
+}
+
+// AwayFilter select online contacts with best presence away or xa
+func AwayFilter(cc *Contact) bool {
+	show := cc.Show()
+	return cc.Online && (show == "away" || show == "xa")
+}
+
+// GroupFilter select contacts in roster group
+func GroupFilter(group string) ContactFilter {
+	return func(cc *Contact) bool {
+		for _, grp := range cc.Group {
+			if grp == group {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// updatePresence update resource presence of contact
+func (w *Jabot) updatePresence(v *xmpp.Presence) {
+	jid := getJid(v.From)
+	resource := ""
+	if a := strings.SplitN(v.From, "/", 2); len(a) == 2 {
+		resource = a[1]
+	}
+	w.cmu.Lock()
+	defer w.cmu.Unlock()
+	cc, ok := w.contacts[jid]
+	if !ok {
+		if v.Type != "" {
+			// unavailable for unknown contact
+			return
+		}
+		cc.Jid = jid
+		cc.Name = nickName(jid)
+		cc.NickName = cc.Name
+	}
+	// copy on write, Contact returned by GetContacts share the map
+	resources := make(map[string]Resource, len(cc.Resources)+1)
+	for k, res := range cc.Resources {
+		resources[k] = res
+	}
+	now := time.Now()
+	switch v.Type {
+	case "":
+		res := resources[resource]
+		res.Show = v.Show
+		res.Status = v.Status
+		res.Priority = v.Priority
+		res.Caps = presenceCaps(v)
+		res.LastSeen = now
+		resources[resource] = res
+	case "unavailable", "error":
+		delete(resources, resource)
+	}
+	cc.Resources = resources
+	cc.Online = len(resources) > 0
+	cc.LastSeen = now
+	w.contacts[jid] = cc
+}
+
+// clearPresence mark all contacts offline, presence of previous
+// connection is stale
+func (w *Jabot) clearPresence() {
+	w.cmu.Lock()
+	defer w.cmu.Unlock()
+	for jid, cc := range w.contacts {
+		if cc.Online || len(cc.Resources) > 0 {
+			cc.Online = false
+			cc.Resources = nil
+			w.contacts[jid] = cc
+		}
+	}
+}
+
+// presenceCaps returns XEP-0115 node#ver of presence, empty if none
+func presenceCaps(v *xmpp.Presence) string {
+	for i := range v.OtherElem {
+		el := &v.OtherElem[i]
+		if el.XMLName.Space != nsCaps || el.XMLName.Local != "c" {
+			continue
+		}
+		if ver := elemAttr(el, "ver"); ver != "" {
+			return elemAttr(el, "node") + "#" + ver
+		}
+	}
+	return ""
+}
+
+var (
+	errShow     = errors.New("show must be one of chat, away, xa, dnd or empty")
+	errPriority = errors.New("priority must be between -128 and 127")
+)
+
+func validPriority(priority int) bool {
+	return priority >= -128 && priority <= 127
+}
+
+func (w *Jabot) initPresence() {
+	w.startTime = time.Now()
+	w.show = w.cfg.Show
+	w.status = w.cfg.Status
+	w.priority = w.cfg.Priority
+	if !validPriority(w.priority) {
+		log.Warning(errPriority, "got", w.priority)
+		w.priority = 0
+	}
+	w.statusVars = map[string]func() string{
+		"uptime": func() string {
+			return time.Now().Sub(w.startTime).Round(time.Second).String()
+		},
+	}
+}
+
+// RegisterStatusVar
+//	register live value for status template, e.g. "queue" for {{.queue}}
+func (w *Jabot) RegisterStatusVar(name string, varFunc func() string) {
+	w.mu.Lock()
+	w.statusVars[name] = varFunc
+	w.mu.Unlock()
+}
+
+// Show returns show of jabot presence
+func (w *Jabot) Show() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.show
+}
+
+// statusText render status template with status vars
+func (w *Jabot) statusText() string {
+	w.mu.Lock()
+	status := w.status
+	data := make(map[string]string, len(w.statusVars))
+	vars := make(map[string]func() string, len(w.statusVars))
+	for k, varFunc := range w.statusVars {
+		vars[k] = varFunc
+	}
+	w.mu.Unlock()
+	if !strings.Contains(status, "{{") {
+		return status
+	}
+	for k, varFunc := range vars {
+		data[k] = varFunc()
+	}
+	tmpl, err := template.New("status").Parse(status)
+	if err != nil {
+		log.Warning("status template", err)
+		return status
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Warning("status template", err)
+		return status
+	}
+	return buf.String()
+}
+
+// sendPresence broadcast presence with show, status, priority,
+// entity capabilities and avatar hash
+func (w *Jabot) sendPresence() error {
+	if !w.IsConnected() {
+		return errNoConn
+	}
+	status := w.statusText()
+	w.mu.Lock()
+	show, priority := w.show, w.priority
+	w.lastStatus = status
+	w.mu.Unlock()
+	pres := "<presence>"
+	if show != "" {
+		pres += "<show>" + show + "</show>"
+	}
+	if status != "" {
+		pres += "<status>" + xmlEscape(status) + "</status>"
+	}
+	if priority != 0 {
+		pres += "<priority>" + strconv.Itoa(priority) + "</priority>"
+	}
+	pres += w.capsXML() + w.vcardUpdateXML() + "</presence>"
+	return w.sendOrg(pres)
+}
+
+// SetPresence
+//	broadcast presence of jabot, status could be text/template with
+//	status vars like {{.uptime}}, refreshed every StatusRefresh seconds
+func (w *Jabot) SetPresence(show, status string, priority int) error {
+	if showRank(show) == 0 {
+		return errShow
+	}
+	if !validPriority(priority) {
+		return errPriority
+	}
+	w.mu.Lock()
+	w.show, w.status, w.priority = show, status, priority
+	w.mu.Unlock()
+	return w.sendPresence()
+}
+
+// statusLoop re-broadcast presence when status template text changed
+func (w *Jabot) statusLoop(done <-chan struct{}) {
+	interval := time.Duration(w.cfg.StatusRefresh) * time.Second
+	if interval <= 0 {
+		return
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+		}
+		w.mu.Lock()
+		last := w.lastStatus
+		w.mu.Unlock()
+		if w.statusText() == last {
+			continue
+		}
+		if err := w.sendPresence(); err != nil {
+			log.Warning("refresh status", err)
+		}
+	}
+}
+
+// presenceCmd admin command: status,show[,text]
+func (w *Jabot) presenceCmd(from, content string) (string, bool) {
+	cmds := strings.SplitN(content, ",", 3)
+	if strings.ToLower(strings.TrimSpace(cmds[0])) != "status" ||
+		!w.isAdmin(from) {
+		return "", false
+	}
+	if len(cmds) < 2 {
+		return "show: " + w.Show() + ", status: " + w.statusText(), true
+	}
+	show := strings.ToLower(strings.TrimSpace(cmds[1]))
+	if show == "online" {
+		show = ""
+	}
+	w.mu.Lock()
+	status, priority := w.status, w.priority
+	w.mu.Unlock()
+	if len(cmds) == 3 {
+		status = strings.TrimSpace(cmds[2])
+	}
+	if err := w.SetPresence(show, status, priority); err != nil {
+		return err.Error(), true
+	}
+	return "presence changed", true
+}
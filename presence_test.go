@@ -0,0 +1,91 @@
+package jabot
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestPresence(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	w.processRoster([]rosterItem{{Jid: "bob@localhost", Subscription: "both",
+		Group: []string{"ops"}}}, false)
+	w.updatePresence(&xmpp.Presence{From: "bob@localhost/phone", Show: "away"})
+	w.updatePresence(&xmpp.Presence{From: "bob@localhost/pc"})
+	w.updatePresence(&xmpp.Presence{From: "eve@localhost/pc", Show: "dnd"})
+	cc, _ := w.getContact("bob@localhost")
+	if res, _, ok := cc.Best(); !ok || res != "pc" {
+		t.Error("Best got", res, ok)
+	}
+	// higher priority wins over better show
+	w.updatePresence(&xmpp.Presence{From: "bob@localhost/phone", Show: "away",
+		Priority: 5, OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: nsCaps, Local: "c"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "node"},
+				Value: "http://conversations.im"},
+				{Name: xml.Name{Local: "ver"}, Value: "abc="}}}}})
+	cc, _ = w.getContact("bob@localhost")
+	if name, res, ok := cc.Best(); !ok || name != "phone" ||
+		res.Priority != 5 || res.Caps != "http://conversations.im#abc=" {
+		t.Error("Best with priority got", name, res, ok)
+	}
+	w.updatePresence(&xmpp.Presence{From: "bob@localhost/phone", Show: "away"})
+	if n := len(w.GetContacts()); n != 2 {
+		t.Error("GetContacts online got", n)
+	}
+	if ccs := w.GetContacts(AvailableFilter); len(ccs) != 1 ||
+		ccs[0].Jid != "bob@localhost" {
+		t.Error("GetContacts available got", ccs)
+	}
+	if ccs := w.GetContacts(OnlineFilter, GroupFilter("ops")); len(ccs) != 1 {
+		t.Error("GetContacts ops got", ccs)
+	}
+	w.clearPresence()
+	if ccs := w.GetContacts(); len(ccs) != 0 {
+		t.Error("online after clearPresence", ccs)
+	}
+	w.updatePresence(&xmpp.Presence{From: "bob@localhost/phone", Show: "away"})
+	w.updatePresence(&xmpp.Presence{From: "bob@localhost/pc"})
+	w.updatePresence(&xmpp.Presence{From: "bob@localhost/pc",
+		Type: "unavailable"})
+	if ccs := w.GetContacts(AwayFilter); len(ccs) != 1 ||
+		ccs[0].Jid != "bob@localhost" {
+		t.Error("GetContacts away got", ccs)
+	}
+	w.updatePresence(&xmpp.Presence{From: "bob@localhost/phone",
+		Type: "unavailable"})
+	if cc, _ := w.getContact("bob@localhost"); cc.Online ||
+		cc.Show() != "unavailable" {
+		t.Error("unavailable got", cc)
+	}
+}
+
+func TestStatusTemplate(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	if s := w.statusText(); s != "I'm gopher jabber" {
+		t.Error("default status got", s)
+	}
+	w.RegisterStatusVar("queue", func() string { return "3" })
+	if err := w.SetPresence("busy", "", 0); err != errShow {
+		t.Error("SetPresence busy got", err)
+	}
+	if err := w.SetPresence("dnd", "queue {{.queue}}, up {{.uptime}}",
+		5); err != errNoConn {
+		t.Error("SetPresence offline got", err)
+	}
+	if err := w.SetPresence("dnd", "", 128); err != errPriority {
+		t.Error("SetPresence priority 128 got", err)
+	}
+	if s := w.statusText(); !strings.HasPrefix(s, "queue 3, up ") {
+		t.Error("status template got", s)
+	}
+	if _, ok := w.presenceCmd("eve@localhost", "status,away"); ok {
+		t.Error("status command from non admin")
+	}
+	if reply, ok := w.presenceCmd("test@localhost/phone", "status"); !ok ||
+		!strings.HasPrefix(reply, "show: dnd") {
+		t.Error("status command got", reply)
+	}
+}
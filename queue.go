@@ -0,0 +1,217 @@
+package jabot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	queuePoll  = time.Millisecond * 500 // check connection while queued
+	retryDelay = time.Second
+)
+
+var (
+	errQueueFull    = errors.New("Send queue full")
+	errQueueExpired = errors.New("Message expired in send queue")
+)
+
+// SendHookFunc type
+//	used for RegisterSendHook, called with outcome of queued message
+type SendHookFunc func(id string, err error)
+
+// Outgoing queued message, done when sent or failed
+type Outgoing struct {
+	ID   string
+	done chan struct{}
+	err  error
+}
+
+// Done returns channel closed when the message is sent or failed
+func (o *Outgoing) Done() <-chan struct{} {
+	return o.done
+}
+
+// Err returns error of done message, nil if sent
+func (o *Outgoing) Err() error {
+	select {
+	case <-o.done:
+		return o.err
+	default:
+		return nil
+	}
+}
+
+// Wait wait for the message sent or failed
+func (o *Outgoing) Wait(ctx context.Context) error {
+	select {
+	case <-o.done:
+		return o.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type queued struct {
+	m   *OutMessage
+	out *Outgoing
+	at  time.Time
+}
+
+// sendQueue outgoing messages, written in order per peer by a goroutine
+// of the peer, so a slow peer never delays others
+type sendQueue struct {
+	mu       sync.Mutex
+	size     int                  // max queued messages
+	n        int                  // queued messages
+	peers    map[string][]*queued // queued by peer, head being written
+	last     time.Time            // last write slot reserved
+	peerLast map[string]time.Time // last write to peer
+}
+
+func newSendQueue(size int) *sendQueue {
+	if size <= 0 {
+		return nil
+	}
+	return &sendQueue{size: size, peers: map[string][]*queued{},
+		peerLast: map[string]time.Time{}}
+}
+
+// nextSlot returns time of next write allowed by rate per second
+func nextSlot(last time.Time, rate float64, now time.Time) time.Time {
+	if rate <= 0 {
+		return now
+	}
+	next := last.Add(time.Duration(float64(time.Second) / rate))
+	if next.Before(now) {
+		return now
+	}
+	return next
+}
+
+// RegisterSendHook
+//	hook called with outcome of every queued message
+func (w *Jabot) RegisterSendHook(hook SendHookFunc) {
+	w.sendHook = hook
+}
+
+// Queue
+//	queue message for sending, buffered while disconnected and retried
+//	on failure, written within SendRate and PeerRate limits. Messages
+//	to a peer are written in order, a slow peer never delays others.
+//	Sent directly if QueueSize is 0
+func (w *Jabot) Queue(m *OutMessage) (*Outgoing, error) {
+	if m.ID == "" {
+		m.ID = w.nextID("msg")
+	}
+	if m.Type == "" {
+		m.Type = "chat"
+	}
+	out := &Outgoing{ID: m.ID, done: make(chan struct{})}
+	if w.outq == nil {
+		_, out.err = w.Send(m)
+		close(out.done)
+		return out, out.err
+	}
+	q := w.outq
+	peer := getJid(m.To)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n >= q.size {
+		return nil, errQueueFull
+	}
+	q.n++
+	items, busy := q.peers[peer]
+	q.peers[peer] = append(items, &queued{m: m, out: out, at: time.Now()})
+	if !busy {
+		go w.sendLoop(q, peer)
+	}
+	return out, nil
+}
+
+// post queue message, returns id of the message
+func (w *Jabot) post(m *OutMessage) (string, error) {
+	out, err := w.Queue(m)
+	if err != nil {
+		return m.ID, err
+	}
+	return out.ID, nil
+}
+
+// sendLoop write queued messages of peer until none left
+func (w *Jabot) sendLoop(q *sendQueue, peer string) {
+	for {
+		q.mu.Lock()
+		items := q.peers[peer]
+		if len(items) == 0 {
+			delete(q.peers, peer)
+			q.mu.Unlock()
+			return
+		}
+		it := items[0]
+		q.mu.Unlock()
+		err := w.deliver(q, it, peer)
+		q.mu.Lock()
+		q.peers[peer] = q.peers[peer][1:]
+		q.n--
+		q.mu.Unlock()
+		it.out.err = err
+		close(it.out.done)
+		if err != nil {
+			log.Warning("send message", it.m.ID, "to", it.m.To, err)
+		}
+		if w.sendHook != nil {
+			w.sendHook(it.m.ID, err)
+		}
+	}
+}
+
+// deliver write queued message, wait for connection and retry
+func (w *Jabot) deliver(q *sendQueue, it *queued, peer string) error {
+	ttl := time.Duration(w.cfg.QueueTTL) * time.Second
+	for retries := 0; ; {
+		if w.isClosed() {
+			return errClosed
+		}
+		if ttl > 0 && time.Now().Sub(it.at) > ttl {
+			return errQueueExpired
+		}
+		if !w.IsConnected() {
+			time.Sleep(queuePoll)
+			continue
+		}
+		// wait for slot of peer first, then reserve global slot
+		now := time.Now()
+		q.mu.Lock()
+		next := nextSlot(q.peerLast[peer], w.cfg.PeerRate, now)
+		q.mu.Unlock()
+		time.Sleep(next.Sub(now))
+		now = time.Now()
+		q.mu.Lock()
+		next = nextSlot(q.last, w.cfg.SendRate, now)
+		q.last = next
+		q.mu.Unlock()
+		time.Sleep(next.Sub(now))
+		_, err := w.Send(it.m)
+		now = time.Now()
+		q.mu.Lock()
+		q.peerLast[peer] = now
+		if len(q.peerLast) > 1000 {
+			for k, tt := range q.peerLast {
+				if now.Sub(tt) > time.Minute {
+					delete(q.peerLast, k)
+				}
+			}
+		}
+		q.mu.Unlock()
+		if err == nil {
+			return nil
+		}
+		if retries++; retries > w.cfg.SendRetries {
+			return err
+		}
+		log.Warning("send message", it.m.ID, "retry", retries, err)
+		time.Sleep(retryDelay * time.Duration(retries))
+	}
+}
@@ -0,0 +1,75 @@
+package jabot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextSlot(t *testing.T) {
+	now := time.Now()
+	if tt := nextSlot(now, 0, now); !tt.Equal(now) {
+		t.Error("nextSlot unlimited got", tt)
+	}
+	if tt := nextSlot(now.Add(-time.Second), 2, now); !tt.Equal(now) {
+		t.Error("nextSlot after interval got", tt)
+	}
+	if tt := nextSlot(now, 2, now); !tt.Equal(now.Add(time.Millisecond * 500)) {
+		t.Error("nextSlot within interval got", tt)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	var outcome error
+	done := make(chan struct{})
+	w.RegisterSendHook(func(id string, err error) {
+		outcome = err
+		close(done)
+	})
+	w.closed = true
+	out, err := w.Queue(&OutMessage{To: "bob@localhost", Body: "hi"})
+	if err != nil {
+		t.Fatal("Queue", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := out.Wait(ctx); err != errClosed {
+		t.Error("queued message of closed jabot got", err)
+	}
+	<-done
+	if outcome != errClosed || out.Err() != errClosed {
+		t.Error("send hook got", outcome)
+	}
+}
+
+func TestQueuePeerRate(t *testing.T) {
+	conf := NewConfig("")
+	conf.QueueSize = 10
+	conf.PeerRate = 1
+	w, _ := NewJabot(&conf)
+	sl, restore := captureStanzas(w)
+	defer restore()
+	w.outq = newSendQueue(conf.QueueSize)
+	for _, to := range []string{"bob@localhost", "bob@localhost",
+		"eve@localhost"} {
+		if _, err := w.Queue(&OutMessage{To: to, Body: "hi"}); err != nil {
+			t.Fatal("Queue", err)
+		}
+	}
+	// second message to bob waits a second, eve not delayed
+	for i := 0; i < 50 && len(sl.all()) < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if stanzas := sl.all(); len(stanzas) != 2 ||
+		!strings.Contains(strings.Join(stanzas, ""), "to='eve@localhost'") {
+		t.Error("message to other peer delayed, got", stanzas)
+	}
+	for i := 0; i < 200 && len(sl.all()) < 3; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := len(sl.all()); n != 3 {
+		t.Error("queued messages sent", n)
+	}
+}
@@ -0,0 +1,159 @@
+package jabot
+
+import (
+	"context"
+	"encoding/xml"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	nsReactions = "urn:xmpp:reactions:0"
+	sentTTL     = time.Hour * 24 // reactions on bot messages accepted
+	sentMax     = 1024           // bot messages remembered for reactions
+)
+
+// sentMsg recipient and time of message sent by jabot
+type sentMsg struct {
+	to string // bare jid
+	at time.Time
+}
+
+// ReactionHookFunc type
+//	used for RegisterReactionHook, called with full set of reactions
+//	from jid on message id sent by jabot, empty if removed
+type ReactionHookFunc func(from, id string, reactions []string)
+
+// Reaction event of WaitReaction
+type Reaction struct {
+	From      string
+	ID        string
+	Reactions []string
+}
+
+type reactionsXML struct {
+	ID       string   `xml:"id,attr"`
+	Reaction []string `xml:"reaction"`
+}
+
+// RegisterReactionHook
+//	hook called when peer reacts on message sent by jabot
+func (w *Jabot) RegisterReactionHook(hook ReactionHookFunc) {
+	w.reactHook = hook
+}
+
+// SendReactions
+//	send XEP-0444 reactions on message id of to, full set replaces
+//	previous reactions, none to remove all
+func (w *Jabot) SendReactions(to, id string, reactions ...string) (string,
+	error) {
+	ext := "<reactions id='" + xmlEscape(id) + "' xmlns='" + nsReactions + "'>"
+	for _, r := range reactions {
+		ext += "<reaction>" + xmlEscape(r) + "</reaction>"
+	}
+	ext += "</reactions>"
+	return w.post(&OutMessage{To: to, Type: "chat",
+		Ext: []string{ext, "<store xmlns='" + nsHints + "'/>"}})
+}
+
+// React
+//	react on message of context with emoji, e.g. 👀 while processing
+//	and ✅ when done, replaces previous reactions of jabot
+func (c *Context) React(reactions ...string) error {
+	if c.bot == nil {
+		return errNoConn
+	}
+	_, err := c.bot.SendReactions(c.Remote, c.ID, reactions...)
+	return err
+}
+
+// sentMessage remember id and recipient of message sent, for reactions,
+// oldest forgotten beyond sentMax
+func (w *Jabot) sentMessage(id, to string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.sentIDs[id]; !ok {
+		w.sentOrder = append(w.sentOrder, id)
+	}
+	w.sentIDs[id] = sentMsg{to: getJid(to), at: time.Now()}
+	for len(w.sentOrder) > sentMax {
+		delete(w.sentIDs, w.sentOrder[0])
+		w.sentOrder = w.sentOrder[1:]
+	}
+}
+
+// WaitReaction
+//	wait for next reaction on message id sent by jabot, e.g. approve
+//	or reject of pending action
+func (w *Jabot) WaitReaction(ctx context.Context, id string) (*Reaction,
+	error) {
+	ch := make(chan *Reaction, 1)
+	w.mu.Lock()
+	w.reactWait[id] = append(w.reactWait[id], ch)
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		chs := w.reactWait[id]
+		for i := range chs {
+			if chs[i] == ch {
+				chs = append(chs[:i], chs[i+1:]...)
+				break
+			}
+		}
+		if len(chs) == 0 {
+			delete(w.reactWait, id)
+		} else {
+			w.reactWait[id] = chs
+		}
+		w.mu.Unlock()
+	}()
+	select {
+	case r := <-ch:
+		return r, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// handleReactions deliver reactions on bot messages, true if m is
+// a reactions message
+func (w *Jabot) handleReactions(m *xmpp.Chat) bool {
+	for _, el := range m.OtherElem {
+		if el.XMLName.Space != nsReactions || el.XMLName.Local != "reactions" {
+			continue
+		}
+		var rx reactionsXML
+		if err := xml.Unmarshal([]byte("<r id='"+
+			xmlEscape(elemAttr(&el, "id"))+"'>"+el.InnerXML+"</r>"),
+			&rx); err != nil {
+			log.Warning("unmarshal reactions", err)
+			return true
+		}
+		w.mu.Lock()
+		sent, ok := w.sentIDs[rx.ID]
+		// only the recipient may react on the message
+		ok = ok && sent.to == getJid(m.Remote) &&
+			time.Now().Sub(sent.at) <= sentTTL
+		var chs []chan *Reaction
+		if ok {
+			chs = w.reactWait[rx.ID]
+			delete(w.reactWait, rx.ID)
+		}
+		w.mu.Unlock()
+		if !ok {
+			log.Info("reactions on unknown message", rx.ID, "from", m.Remote)
+			return true
+		}
+		log.Info("reactions from", m.Remote, "on", rx.ID, rx.Reaction)
+		r := &Reaction{From: m.Remote, ID: rx.ID, Reactions: rx.Reaction}
+		for _, ch := range chs {
+			ch <- r
+		}
+		if w.reactHook != nil {
+			w.reactHook(m.Remote, rx.ID, rx.Reaction)
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package jabot
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestReactions(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	var hooked []string
+	w.RegisterReactionHook(func(from, id string, reactions []string) {
+		hooked = append(hooked, id)
+	})
+	w.sentMessage("m1", "bob@localhost/pc")
+	reactions := func(from, id string) *xmpp.Chat {
+		return &xmpp.Chat{Remote: from,
+			OtherElem: []xmpp.XMLElement{{
+				XMLName:  xml.Name{Space: nsReactions, Local: "reactions"},
+				Attr:     []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+				InnerXML: "<reaction>👍</reaction><reaction>✅</reaction>"}}}
+	}
+	res := make(chan *Reaction, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		r, _ := w.WaitReaction(ctx, "m1")
+		res <- r
+	}()
+	for i := 0; ; i++ {
+		w.mu.Lock()
+		n := len(w.reactWait["m1"])
+		w.mu.Unlock()
+		if n > 0 || i > 100 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !w.handleReactions(reactions("bob@localhost/pc", "m2")) ||
+		!w.handleReactions(reactions("mallory@localhost/pc", "m1")) {
+		t.Fatal("reactions not handled")
+	}
+	if len(hooked) != 0 {
+		t.Error("reactions of stranger delivered", hooked)
+	}
+	if !w.handleReactions(reactions("bob@localhost/phone", "m1")) {
+		t.Fatal("reactions not handled")
+	}
+	r := <-res
+	if r == nil || r.From != "bob@localhost/phone" || len(r.Reactions) != 2 ||
+		r.Reactions[0] != "👍" {
+		t.Error("WaitReaction got", r)
+	}
+	if len(hooked) != 1 || hooked[0] != "m1" {
+		t.Error("reaction hook got", hooked)
+	}
+	if w.handleReactions(&xmpp.Chat{Remote: "bob@localhost/pc", Text: "hi"}) {
+		t.Error("plain message handled as reactions")
+	}
+}
+
+func TestSentMessageCap(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	for i := 0; i < sentMax+10; i++ {
+		w.sentMessage("m"+strconv.Itoa(i), "bob@localhost")
+	}
+	w.sentMessage("m20", "bob@localhost")
+	if len(w.sentIDs) != sentMax || len(w.sentOrder) != sentMax {
+		t.Error("sentIDs not capped", len(w.sentIDs), len(w.sentOrder))
+	}
+	if _, ok := w.sentIDs["m9"]; ok {
+		t.Error("oldest sent message kept")
+	}
+}
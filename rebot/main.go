@@ -11,6 +11,8 @@ import (
 
 var username = flag.String("username", "test@localhost", "username")
 var password = flag.String("password", "testme", "password")
+var nick = flag.String("nick", "", "nickname published in vCard")
+var avatar = flag.String("avatar", "", "avatar PNG/JPEG file, e.g. gopher.png")
 
 func main() {
 	flag.Usage = func() {
@@ -22,6 +24,8 @@ func main() {
 	cfg := jabot.NewConfig("")
 	cfg.Jid = *username
 	cfg.Passwd = *password
+	cfg.Profile.NickName = *nick
+	cfg.Profile.Avatar = *avatar
 	rebot, err := jabot.NewJabot(&cfg)
 	if err != nil {
 		panic(err)
@@ -0,0 +1,160 @@
+package jabot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	nsReceipts = "urn:xmpp:receipts"
+	nsMarkers  = "urn:xmpp:chat-markers:0"
+	receiptTTL = time.Hour * 24
+)
+
+// markers of XEP-0184 receipt and XEP-0333 chat markers
+const (
+	MarkerReceived     = "received"
+	MarkerDisplayed    = "displayed"
+	MarkerAcknowledged = "acknowledged"
+)
+
+var errNotTracked = errors.New("message not tracked for receipt")
+
+// ReceiptHookFunc type
+//	used for RegisterReceiptHook, called when message id sent to from
+//	is marked received, displayed or acknowledged
+type ReceiptHookFunc func(from, id, marker string)
+
+type msgTrack struct {
+	to        string // bare jid of recipient
+	at        time.Time
+	received  chan struct{} // closed when received
+	displayed chan struct{} // closed when displayed or acknowledged
+}
+
+// RegisterReceiptHook
+//	hook called with receipts and markers for sent messages
+func (w *Jabot) RegisterReceiptHook(hook ReceiptHookFunc) {
+	w.rcptHook = hook
+}
+
+func elemAttr(el *xmpp.XMLElement, name string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// receiptExt request receipt and mark message as markable
+func receiptExt() []string {
+	return []string{"<request xmlns='" + nsReceipts + "'/>",
+		"<markable xmlns='" + nsMarkers + "'/>"}
+}
+
+// trackMessage wait receipts for message id sent to
+func (w *Jabot) trackMessage(id, to string) {
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for k, tr := range w.tracks {
+		if now.Sub(tr.at) > receiptTTL {
+			delete(w.tracks, k)
+		}
+	}
+	w.tracks[id] = &msgTrack{to: getJid(to), at: now, received: make(chan struct{}),
+		displayed: make(chan struct{})}
+}
+
+func closeOnce(ch chan struct{}) {
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// markMessage update tracked message id with marker, only the
+// recipient may mark the message
+func (w *Jabot) markMessage(from, id, marker string) {
+	w.mu.Lock()
+	tr, ok := w.tracks[id]
+	if ok && tr.to == getJid(from) {
+		closeOnce(tr.received)
+		if marker != MarkerReceived {
+			closeOnce(tr.displayed)
+		}
+	}
+	w.mu.Unlock()
+	if !ok || tr.to != getJid(from) {
+		log.Infof("ignore %s of message %s from %s", marker, id, from)
+		return
+	}
+	log.Infof("message %s to %s %s", id, from, marker)
+	if w.rcptHook != nil {
+		w.rcptHook(from, id, marker)
+	}
+}
+
+// WaitReceipt
+//	wait message id sent with receipt request until marker received or
+//	displayed (acknowledged counted as displayed)
+func (w *Jabot) WaitReceipt(ctx context.Context, id, marker string) error {
+	w.mu.Lock()
+	tr, ok := w.tracks[id]
+	w.mu.Unlock()
+	if !ok {
+		return errNotTracked
+	}
+	ch := tr.received
+	if marker != MarkerReceived {
+		ch = tr.displayed
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ch:
+		return nil
+	}
+}
+
+// SendMessageReceipt send chat message with receipt request, returns id
+// for WaitReceipt
+func (w *Jabot) SendMessageReceipt(message string, to string) (string, error) {
+	id := w.nextID("msg")
+	w.trackMessage(id, to)
+	return w.post(&OutMessage{To: to, Type: "chat", ID: id, Body: message,
+		Ext: receiptExt()})
+}
+
+// handleReceipts answer receipt request and process incoming markers
+func (w *Jabot) handleReceipts(m *xmpp.Chat) {
+	for i := range m.OtherElem {
+		el := &m.OtherElem[i]
+		switch el.XMLName.Space {
+		case nsReceipts:
+			switch el.XMLName.Local {
+			case "request":
+				if m.ID == "" || m.Type == "groupchat" || m.Type == "error" {
+					continue
+				}
+				if _, err := w.Send(&OutMessage{To: m.Remote, Type: m.Type,
+					Ext: []string{"<received xmlns='" + nsReceipts +
+						"' id='" + xmlEscape(m.ID) + "'/>"}}); err != nil {
+					log.Warning("send receipt", err)
+				}
+			case "received":
+				w.markMessage(m.Remote, elemAttr(el, "id"), MarkerReceived)
+			}
+		case nsMarkers:
+			switch el.XMLName.Local {
+			case MarkerReceived, MarkerDisplayed, MarkerAcknowledged:
+				w.markMessage(m.Remote, elemAttr(el, "id"), el.XMLName.Local)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package jabot
+
+import (
+	"context"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestOutMessageXML(t *testing.T) {
+	m := OutMessage{To: "bob@localhost", Type: "chat", ID: "m1",
+		Body: "a<b", Ext: receiptExt()}
+	if s := m.XML(); s != "<message to='bob@localhost' type='chat' id='m1'>"+
+		"<body>a&lt;b</body><request xmlns='urn:xmpp:receipts'/>"+
+		"<markable xmlns='urn:xmpp:chat-markers:0'/></message>" {
+		t.Error("OutMessage XML got", s)
+	}
+}
+
+func TestReceipts(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	var marks []string
+	w.RegisterReceiptHook(func(from, id, marker string) {
+		marks = append(marks, id+" "+marker)
+	})
+	w.trackMessage("m1", "bob@localhost")
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Millisecond*10)
+	defer cancel()
+	if err := w.WaitReceipt(ctx, "m1", MarkerReceived); err == nil {
+		t.Error("WaitReceipt before receipt")
+	}
+	if err := w.WaitReceipt(ctx, "m2", MarkerReceived); err != errNotTracked {
+		t.Error("WaitReceipt untracked got", err)
+	}
+	w.handleReceipts(&xmpp.Chat{Remote: "mallory@localhost/pc", Type: "chat",
+		OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: nsMarkers, Local: "displayed"},
+			Attr:    []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "m1"}},
+		}}})
+	if err := w.WaitReceipt(ctx, "m1", MarkerDisplayed); err == nil ||
+		len(marks) != 0 {
+		t.Error("marker of stranger accepted", marks)
+	}
+	w.handleReceipts(&xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat",
+		OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: nsReceipts, Local: "received"},
+			Attr:    []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "m1"}},
+		}}})
+	if err := w.WaitReceipt(context.Background(), "m1",
+		MarkerReceived); err != nil {
+		t.Error("WaitReceipt received", err)
+	}
+	w.handleReceipts(&xmpp.Chat{Remote: "bob@localhost/pc", Type: "chat",
+		OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: nsMarkers, Local: "displayed"},
+			Attr:    []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "m1"}},
+		}}})
+	if err := w.WaitReceipt(context.Background(), "m1",
+		MarkerDisplayed); err != nil {
+		t.Error("WaitReceipt displayed", err)
+	}
+	if len(marks) != 2 || marks[1] != "m1 displayed" {
+		t.Error("receipt hook got", marks)
+	}
+}
@@ -0,0 +1,295 @@
+package jabot
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const nsRoster = "jabber:iq:roster"
+
+type rosterItem struct {
+	XMLName      xml.Name `xml:"item"`
+	Jid          string   `xml:"jid,attr"`
+	Name         string   `xml:"name,attr"`
+	Subscription string   `xml:"subscription,attr"`
+	Group        []string `xml:"group"`
+}
+
+type rosterItems struct {
+	Ver   string       `xml:"ver,attr"`
+	Items []rosterItem `xml:"item"`
+}
+
+// rosterCache persisted roster with XEP-0237 version
+type rosterCache struct {
+	Ver      string
+	Contacts []Contact
+}
+
+func (it *rosterItem) xml() string {
+	res := "<item jid='" + xmlEscape(it.Jid) + "'"
+	if it.Name != "" {
+		res += " name='" + xmlEscape(it.Name) + "'"
+	}
+	if it.Subscription != "" {
+		res += " subscription='" + it.Subscription + "'"
+	}
+	res += ">"
+	for _, grp := range it.Group {
+		res += "<group>" + xmlEscape(grp) + "</group>"
+	}
+	return res + "</item>"
+}
+
+func rosterSetBody(item *rosterItem) string {
+	return "<query xmlns='" + nsRoster + "'>" + item.xml() + "</query>"
+}
+
+// processRoster update contacts with roster result or push
+func (w *Jabot) processRoster(items []rosterItem, push bool) {
+	for _, item := range items {
+		cc, ok := w.getContact(item.Jid)
+		if !ok {
+			cc.Jid = item.Jid
+		}
+		if item.Name != "" {
+			cc.Name = item.Name
+		}
+		if item.Subscription == "" {
+			// servers omit the attribute for none
+			item.Subscription = "none"
+		}
+		cc.Subscription = item.Subscription
+		cc.Group = item.Group
+		if item.Subscription == "remove" {
+			w.cmu.Lock()
+			delete(w.contacts, item.Jid)
+			w.cmu.Unlock()
+			log.Infof("roster item %s removed", item.Jid)
+			continue
+		}
+		if cc.Jid != "" {
+			w.updateContacts(&cc)
+			/*
+				// never query vcard here, may loops
+				if cc.Name == "" || cc.NickName == "" {
+					// try vCard
+				}
+			*/
+		}
+		if item.Subscription == "from" && cc.Online &&
+			w.cfg.Subscription.Mutual {
+			log.Infof("roster: Approve %s subscription", cc.Jid)
+			//w.client.ApproveSubscription(cc.Jid)
+			w.sendPresenceTo(cc.Jid, "subscribe", "")
+		}
+		log.Infof("roster item %s subscription(%s), %v\n",
+			item.Jid, item.Subscription, item.Group)
+		if push && item.Subscription == "both" {
+			// shall we check presence unavailable
+			w.sendPresenceTo(item.Jid, "", w.Show())
+		}
+	}
+}
+
+func (w *Jabot) iqRoster(iq *xmpp.IQ) (string, error) {
+	var roster rosterItems
+	if iq.Type != "result" && iq.Type != "set" {
+		// only result and set processed
+		log.Info("jabber:iq:roster, type:", iq.Type)
+		return "", ErrBadRequest
+	}
+	if iq.Type == "set" && iq.From != "" && getJid(iq.From) != getJid(w.cfg.Jid) {
+		// roster push only allowed from our own account
+		return "", ErrServiceUnavailable
+	}
+	if err := xml.Unmarshal(iq.Query, &roster); err != nil {
+		log.Error("unmarshal roster <query>: ", err)
+		return "", ErrBadRequest
+	}
+	if iq.Type == "result" {
+		w.replaceRoster(roster.Items)
+	} else {
+		w.processRoster(roster.Items, true)
+	}
+	w.saveRoster(roster.Ver)
+	return "", nil
+}
+
+// replaceRoster update contacts with full roster, drop contacts not in it
+func (w *Jabot) replaceRoster(items []rosterItem) {
+	inRoster := map[string]bool{}
+	for _, item := range items {
+		inRoster[item.Jid] = true
+	}
+	w.cmu.Lock()
+	for jid, cc := range w.contacts {
+		if cc.Subscription != "" && !inRoster[jid] {
+			delete(w.contacts, jid)
+		}
+	}
+	w.cmu.Unlock()
+	w.processRoster(items, false)
+}
+
+// GetRoster
+//	request roster with cached version, result processed by Dail.
+//	Server returns empty result if cached roster is up to date,
+//	or pushes changes since the version
+func (w *Jabot) GetRoster() error {
+	w.cmu.RLock()
+	ver := w.rosterVer
+	w.cmu.RUnlock()
+	query := "<query xmlns='" + nsRoster + "'/>"
+	if w.cfg.RosterCache != "" {
+		query = "<query xmlns='" + nsRoster + "' ver='" + xmlEscape(ver) +
+			"'/>"
+	}
+	_, err := w.sendIQFunc(getJid(w.cfg.Jid), "get", query,
+		func(iq *xmpp.IQ) {
+			if err := iqError(iq); err != nil {
+				log.Warning("get roster", err)
+				return
+			}
+			if len(iq.Query) == 0 {
+				log.Info("roster version", ver, "up to date")
+				return
+			}
+			if _, err := w.iqRoster(iq); err != nil {
+				log.Warning("get roster", err)
+			}
+		})
+	return err
+}
+
+// loadRoster load contacts and roster version from RosterCache
+func (w *Jabot) loadRoster() error {
+	if w.cfg.RosterCache == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(w.cfg.RosterCache)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var cache rosterCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return err
+	}
+	w.cmu.Lock()
+	defer w.cmu.Unlock()
+	for _, cc := range cache.Contacts {
+		cc.Online = false
+		cc.Resources = nil
+		w.contacts[cc.Jid] = cc
+	}
+	w.rosterVer = cache.Ver
+	log.Infof("load %d contacts of roster version %s", len(cache.Contacts),
+		cache.Ver)
+	return nil
+}
+
+// saveRoster save roster contacts with version to RosterCache
+func (w *Jabot) saveRoster(ver string) {
+	if w.cfg.RosterCache == "" {
+		return
+	}
+	w.cmu.Lock()
+	if ver != "" {
+		w.rosterVer = ver
+	}
+	cache := rosterCache{Ver: w.rosterVer}
+	for _, cc := range w.contacts {
+		if cc.Subscription == "" {
+			// not in roster, roster items have at least none
+			continue
+		}
+		cc.Online = false
+		cc.Resources = nil
+		cc.Avatar = nil
+		cache.Contacts = append(cache.Contacts, cc)
+	}
+	w.cmu.Unlock()
+	data, err := json.Marshal(&cache)
+	if err != nil {
+		log.Error("marshal roster cache", err)
+		return
+	}
+	tmpName := w.cfg.RosterCache + ".tmp"
+	if err := ioutil.WriteFile(tmpName, data, 0644); err != nil {
+		log.Error("save roster cache", err)
+		return
+	}
+	if err := os.Rename(tmpName, w.cfg.RosterCache); err != nil {
+		log.Error("save roster cache", err)
+	}
+}
+
+// FetchRoster
+//	get roster and wait for the result, returns all contacts of roster.
+//	must not be called from handlers run by Dail
+func (w *Jabot) FetchRoster(ctx context.Context) ([]Contact, error) {
+	iq, err := w.SendIQ(ctx, getJid(w.cfg.Jid), "get",
+		"<query xmlns='"+nsRoster+"'/>")
+	if err != nil {
+		return nil, err
+	}
+	var roster rosterItems
+	if err := xml.Unmarshal(iq.Query, &roster); err != nil {
+		return nil, err
+	}
+	w.replaceRoster(roster.Items)
+	w.saveRoster(roster.Ver)
+	res := make([]Contact, 0, len(roster.Items))
+	for _, item := range roster.Items {
+		if cc, ok := w.getContact(item.Jid); ok {
+			res = append(res, cc)
+		}
+	}
+	return res, nil
+}
+
+// setRosterItem roster set and wait for the result
+func (w *Jabot) setRosterItem(item *rosterItem) error {
+	_, err := w.requestIQ(getJid(w.cfg.Jid), "set", rosterSetBody(item))
+	return err
+}
+
+// AddContact add or update jid of roster with name and groups
+func (w *Jabot) AddContact(jid, name string, groups []string) error {
+	return w.setRosterItem(&rosterItem{Jid: getJid(jid), Name: name,
+		Group: groups})
+}
+
+// RemoveContact remove jid from roster, cancel subscriptions both ways
+func (w *Jabot) RemoveContact(jid string) error {
+	return w.setRosterItem(&rosterItem{Jid: getJid(jid),
+		Subscription: "remove"})
+}
+
+// RenameContact change name of jid in roster, keep groups
+func (w *Jabot) RenameContact(jid, name string) error {
+	jid = getJid(jid)
+	cc, ok := w.getContact(jid)
+	if !ok {
+		return ErrItemNotFound
+	}
+	return w.setRosterItem(&rosterItem{Jid: jid, Name: name, Group: cc.Group})
+}
+
+// SetGroups change groups of jid in roster, keep name
+func (w *Jabot) SetGroups(jid string, groups []string) error {
+	jid = getJid(jid)
+	cc, ok := w.getContact(jid)
+	if !ok {
+		return ErrItemNotFound
+	}
+	return w.setRosterItem(&rosterItem{Jid: jid, Name: cc.Name, Group: groups})
+}
@@ -0,0 +1,117 @@
+package jabot
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// chunkPrefix numbered prefix of chunk i of n
+func chunkPrefix(i, n int) string {
+	return "[" + strconv.Itoa(i) + "/" + strconv.Itoa(n) + "] "
+}
+
+// cutBody returns length of first part of s no longer than max bytes,
+// cut on line, word or rune boundary
+func cutBody(s string, max int) int {
+	if len(s) <= max {
+		return len(s)
+	}
+	if i := strings.LastIndex(s[:max+1], "\n"); i > 0 {
+		return i
+	}
+	if i := strings.LastIndexAny(s[:max+1], " \t"); i > 0 {
+		return i
+	}
+	n := max
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	if n == 0 {
+		// max shorter than a rune
+		_, n = utf8.DecodeRuneInString(s)
+	}
+	return n
+}
+
+// splitBody split body into numbered chunks of max bytes at most
+func splitBody(body string, max int) []string {
+	if len(body) <= max {
+		return []string{body}
+	}
+	var parts []string
+	// prefix length depends on number of chunks, retry until fit
+	for digits := 1; ; digits++ {
+		room := max - len(chunkPrefix(1, 1)) - 2*(digits-1)
+		if room <= 0 {
+			room = 1
+		}
+		parts = parts[:0]
+		for s := body; s != ""; {
+			n := cutBody(s, room)
+			if part := strings.TrimRight(s[:n], " \t\n"); part != "" {
+				parts = append(parts, part)
+			}
+			s = strings.TrimLeft(s[n:], " \t\n")
+		}
+		if len(strconv.Itoa(len(parts))) <= digits {
+			break
+		}
+	}
+	for i := range parts {
+		parts[i] = chunkPrefix(i+1, len(parts)) + parts[i]
+	}
+	return parts
+}
+
+// waitChunk wait for ChunkInterval since last chunk sent to jid
+func (w *Jabot) waitChunk(jid string) {
+	interval := time.Duration(w.cfg.ChunkInterval) * time.Millisecond
+	if interval <= 0 {
+		return
+	}
+	jid = getJid(jid)
+	now := time.Now()
+	w.mu.Lock()
+	next := w.chunkAt[jid].Add(interval)
+	if next.Before(now) {
+		next = now
+	}
+	w.chunkAt[jid] = next
+	w.mu.Unlock()
+	time.Sleep(next.Sub(now))
+}
+
+// sendLong
+//	send message longer than MaxBody, uploaded as file if more than
+//	MaxChunks chunks and HTTP upload available, otherwise split into
+//	numbered chunks. First message sent with id, generated if empty.
+//	Blocks between chunks and on upload, never call from Dail goroutine,
+//	returns id of first message
+func (w *Jabot) sendLong(message, to, id string) (string, error) {
+	chunks := splitBody(message, w.cfg.MaxBody)
+	if w.cfg.MaxChunks > 0 && len(chunks) > w.cfg.MaxChunks {
+		url, err := w.uploadReader("reply.txt", strings.NewReader(message),
+			"text/plain; charset=utf-8")
+		if err == nil {
+			return w.sendURL(id, to, url, "")
+		}
+		log.Warning("upload long message", err)
+	}
+	first := ""
+	for i, chunk := range chunks {
+		w.waitChunk(to)
+		if i > 0 {
+			id = ""
+		}
+		cid, err := w.sendChat(id, chunk, to)
+		if i == 0 {
+			first = cid
+		}
+		if err != nil {
+			return first, err
+		}
+	}
+	return first, nil
+}
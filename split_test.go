@@ -0,0 +1,67 @@
+package jabot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestSplitBody(t *testing.T) {
+	if parts := splitBody("short", 100); len(parts) != 1 ||
+		parts[0] != "short" {
+		t.Error("splitBody short got", parts)
+	}
+	body := "line one\nline two is longer\nthree"
+	parts := splitBody(body, 20)
+	want := []string{"[1/3] line one", "[2/3] line two is", "[3/3] longer\nthree"}
+	if strings.Join(parts, "|") != strings.Join(want, "|") {
+		t.Errorf("splitBody got %q", parts)
+	}
+	body = strings.Repeat("新闻", 40)
+	parts = splitBody(body, 32)
+	joined := ""
+	for i, part := range parts {
+		if len(part) > 32 {
+			t.Errorf("chunk %d too long %q", i, part)
+		}
+		joined += part[len(chunkPrefix(i+1, len(parts))):]
+	}
+	if joined != body {
+		t.Errorf("splitBody lost content %q", joined)
+	}
+}
+
+func TestSendLongFromHandle(t *testing.T) {
+	conf := NewConfig("")
+	conf.MaxBody = 30
+	conf.MaxChunks = 0
+	conf.ChunkInterval = 200
+	conf.Subscription.Admins = []string{"boss@localhost"}
+	w, _ := NewJabot(&conf)
+	sl, restore := captureStanzas(w)
+	defer restore()
+	w.cmu.Lock()
+	for _, jid := range []string{"a@localhost", "b@localhost",
+		"c@localhost", "d@localhost"} {
+		w.subQueue[jid] = time.Now()
+	}
+	w.cmu.Unlock()
+	st := time.Now()
+	if err := w.handle(&xmpp.Chat{Remote: "boss@localhost/pc", Type: "chat",
+		Text: "pending"}); err != nil {
+		t.Error("handle", err)
+	}
+	if d := time.Now().Sub(st); d > time.Millisecond*100 {
+		t.Error("handle blocked sending long reply", d)
+	}
+	for i := 0; i < 200 && len(sl.all()) < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	stanzas := sl.all()
+	if len(stanzas) != 2 || !strings.Contains(stanzas[0], "[1/2] ") ||
+		!strings.Contains(stanzas[1], "[2/2] ") {
+		t.Error("long reply chunks got", stanzas)
+	}
+}
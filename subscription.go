@@ -0,0 +1,264 @@
+package jabot
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	subQueueMax = 100            // queued requests, oldest denied beyond
+	subQueueTTL = time.Hour * 24 // queued request expired
+)
+
+// SubscribeAction decision for presence subscription request
+type SubscribeAction int
+
+const (
+	SubscribeDefault SubscribeAction = iota // apply SubscriptionPolicy
+	SubscribeApprove
+	SubscribeDeny
+	SubscribeQueue // wait for admin approve/reject
+)
+
+// SubscribeHookFunc type
+//	used for RegisterSubscribeHook, decide subscription request from jid
+type SubscribeHookFunc func(jid string) SubscribeAction
+
+// SubscriptionPolicy for presence subscription requests,
+// patterns are bare jid, glob like *@example.com or domain only
+type SubscriptionPolicy struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+	// Queue unmatched requests for admin instead of drop
+	Queue bool `yaml:"queue"`
+	// Admins approve/reject queued requests by chat command
+	Admins []string `yaml:"admins"`
+	// Group of roster for approved contacts
+	Group string `yaml:"group"`
+	// Mutual request subscription back
+	Mutual bool `yaml:"mutual"`
+}
+
+// matchJid check bare jid against pattern
+func matchJid(pattern, jid string) bool {
+	jid = strings.ToLower(getJid(jid))
+	pattern = strings.ToLower(pattern)
+	if !strings.Contains(pattern, "@") {
+		// domain only
+		if a := strings.SplitN(jid, "@", 2); len(a) == 2 {
+			jid = a[1]
+		}
+	}
+	ok, err := path.Match(pattern, jid)
+	return ok && err == nil
+}
+
+func matchAny(patterns []string, jid string) bool {
+	for _, pattern := range patterns {
+		if matchJid(pattern, jid) {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterSubscribeHook
+//	hook called before allow list, SubscribeDefault to apply policy
+func (w *Jabot) RegisterSubscribeHook(hook SubscribeHookFunc) {
+	w.subHook = hook
+}
+
+// subscribeAction decide subscription request from jid
+func (w *Jabot) subscribeAction(jid string) SubscribeAction {
+	policy := &w.cfg.Subscription
+	if matchAny(policy.Deny, jid) {
+		return SubscribeDeny
+	}
+	if w.subHook != nil {
+		if act := w.subHook(jid); act != SubscribeDefault {
+			return act
+		}
+	}
+	if len(policy.Allow) == 0 {
+		// only same domain allowed by default
+		if getDomain(jid) == w.cfg.Domain {
+			return SubscribeApprove
+		}
+	} else if matchAny(policy.Allow, jid) {
+		return SubscribeApprove
+	}
+	if policy.Queue {
+		return SubscribeQueue
+	}
+	return SubscribeDeny
+}
+
+func (w *Jabot) handleSubscribe(from string) {
+	jid := getJid(from)
+	switch w.subscribeAction(jid) {
+	case SubscribeApprove:
+		log.Infof("Presence: Approve %s subscription", jid)
+		w.approveSubscription(jid)
+	case SubscribeQueue:
+		queued, denied := w.queueSubscribe(jid)
+		if denied != "" {
+			log.Infof("Presence: %s subscription denied, queue full", denied)
+			w.sendPresenceTo(denied, "unsubscribed", "")
+		}
+		if !queued {
+			// repeated request, admins already notified
+			return
+		}
+		log.Infof("Presence: %s subscription queued", jid)
+		w.notifyAdmins("subscription request from " + jid +
+			", reply approve," + jid + " or reject," + jid)
+	default:
+		log.Infof("Presence: %s subscription denied", jid)
+		w.sendPresenceTo(jid, "unsubscribed", "")
+	}
+}
+
+// queueSubscribe queue subscription request of jid, false if already
+// queued. Expired requests dropped, oldest denied if queue is full
+func (w *Jabot) queueSubscribe(jid string) (bool, string) {
+	now := time.Now()
+	w.cmu.Lock()
+	defer w.cmu.Unlock()
+	w.expireSubQueue(now)
+	if _, ok := w.subQueue[jid]; ok {
+		return false, ""
+	}
+	var oldest string
+	if len(w.subQueue) >= subQueueMax {
+		for k, tt := range w.subQueue {
+			if oldest == "" || tt.Before(w.subQueue[oldest]) {
+				oldest = k
+			}
+		}
+		delete(w.subQueue, oldest)
+	}
+	w.subQueue[jid] = now
+	return true, oldest
+}
+
+// expireSubQueue drop requests older than subQueueTTL, cmu locked
+func (w *Jabot) expireSubQueue(now time.Time) {
+	for k, tt := range w.subQueue {
+		if now.Sub(tt) > subQueueTTL {
+			delete(w.subQueue, k)
+		}
+	}
+}
+
+func (w *Jabot) approveSubscription(jid string) {
+	policy := &w.cfg.Subscription
+	w.sendPresenceTo(jid, "subscribed", "")
+	if policy.Group != "" {
+		body := rosterSetBody(&rosterItem{Jid: jid,
+			Group: []string{policy.Group}})
+		if _, err := w.sendIQFunc(getJid(w.cfg.Jid), "set", body,
+			func(iq *xmpp.IQ) {
+				if err := iqError(iq); err != nil {
+					log.Warning("roster group for", jid, err)
+				}
+			}); err != nil {
+			log.Warning("roster group for", jid, err)
+		}
+	}
+	if policy.Mutual {
+		w.sendPresenceTo(jid, "subscribe", "")
+	}
+}
+
+func (w *Jabot) notifyAdmins(msg string) {
+	for _, admin := range w.cfg.Subscription.Admins {
+		if _, err := w.SendMessage(msg, admin); err != nil {
+			log.Warning("notify admin", admin, err)
+		}
+	}
+}
+
+func (w *Jabot) isAdmin(jid string) bool {
+	jid = getJid(jid)
+	if jid == getJid(w.cfg.Jid) {
+		return true
+	}
+	for _, admin := range w.cfg.Subscription.Admins {
+		if getJid(admin) == jid {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingSubscriptions returns jids of queued subscription requests
+func (w *Jabot) PendingSubscriptions() []string {
+	w.cmu.Lock()
+	defer w.cmu.Unlock()
+	w.expireSubQueue(time.Now())
+	res := make([]string, 0, len(w.subQueue))
+	for jid := range w.subQueue {
+		res = append(res, jid)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ApprovePending approve or reject queued subscription request of jid
+func (w *Jabot) ApprovePending(jid string, approve bool) bool {
+	jid = getJid(jid)
+	w.cmu.Lock()
+	_, ok := w.subQueue[jid]
+	delete(w.subQueue, jid)
+	w.cmu.Unlock()
+	if !ok {
+		return false
+	}
+	if approve {
+		log.Infof("Approve pending %s subscription", jid)
+		w.approveSubscription(jid)
+	} else {
+		log.Infof("Reject pending %s subscription", jid)
+		w.sendPresenceTo(jid, "unsubscribed", "")
+	}
+	return true
+}
+
+// adminCmd commands only accepted from admins or myself
+func (w *Jabot) adminCmd(from, content string) (string, bool) {
+	if reply, ok := w.subscriptionCmd(from, content); ok {
+		return reply, ok
+	}
+	return w.presenceCmd(from, content)
+}
+
+// subscriptionCmd admin commands: pending, approve,jid and reject,jid
+func (w *Jabot) subscriptionCmd(from, content string) (string, bool) {
+	cmds := strings.Split(content, ",")
+	cmd := strings.ToLower(strings.TrimSpace(cmds[0]))
+	if cmd != "pending" && cmd != "approve" && cmd != "reject" {
+		return "", false
+	}
+	if !w.isAdmin(from) {
+		return "", false
+	}
+	if cmd == "pending" {
+		pending := w.PendingSubscriptions()
+		if len(pending) == 0 {
+			return "no pending subscription", true
+		}
+		return strings.Join(pending, "\n"), true
+	}
+	if len(cmds) < 2 {
+		return cmd + ",jid", true
+	}
+	jid := strings.TrimSpace(cmds[1])
+	if !w.ApprovePending(jid, cmd == "approve") {
+		return "no pending subscription from " + jid, true
+	}
+	return cmd + "d " + jid, true
+}
@@ -0,0 +1,265 @@
+package jabot
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	nsUpload = "urn:xmpp:http:upload:0"
+	nsOOB    = "jabber:x:oob"
+)
+
+var (
+	errNoUpload     = errors.New("No HTTP upload service")
+	errFileTooLarge = errors.New("File too large for upload service")
+	errSlotURL      = errors.New("Invalid upload slot URL")
+)
+
+// uploadClient for HTTP upload, replaced by tests
+var uploadClient = &http.Client{Timeout: time.Minute * 5}
+
+// UploadSlot XEP-0363 slot, PUT the file to PutURL with Header,
+// then share GetURL
+type UploadSlot struct {
+	PutURL string
+	Header http.Header
+	GetURL string
+}
+
+type uploadService struct {
+	jid     string
+	maxSize int64 // 0 for unknown
+}
+
+type slotXML struct {
+	XMLName xml.Name `xml:"urn:xmpp:http:upload:0 slot"`
+	Put     struct {
+		URL    string `xml:"url,attr"`
+		Header []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"header"`
+	} `xml:"put"`
+	Get struct {
+		URL string `xml:"url,attr"`
+	} `xml:"get"`
+}
+
+func uploadRequestXML(name string, size int64, mimeType string) string {
+	return "<request xmlns='" + nsUpload + "' filename='" + xmlEscape(name) +
+		"' size='" + strconv.FormatInt(size, 10) + "' content-type='" +
+		xmlEscape(mimeType) + "'/>"
+}
+
+func validURL(u string) bool {
+	return strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://")
+}
+
+// validSlotURL XEP-0363 requires HTTPS for put and get URL
+func validSlotURL(u string) bool {
+	return strings.HasPrefix(u, "https://")
+}
+
+// parseSlot parse slot, only headers allowed by XEP-0363 kept
+func parseSlot(data []byte) (*UploadSlot, error) {
+	var slot slotXML
+	if err := xml.Unmarshal(data, &slot); err != nil {
+		return nil, err
+	}
+	if !validSlotURL(slot.Put.URL) || !validSlotURL(slot.Get.URL) {
+		return nil, errSlotURL
+	}
+	res := UploadSlot{PutURL: slot.Put.URL, GetURL: slot.Get.URL,
+		Header: http.Header{}}
+	for _, hdr := range slot.Put.Header {
+		switch name := http.CanonicalHeaderKey(hdr.Name); name {
+		case "Authorization", "Cookie", "Expires":
+			value := strings.NewReplacer("\r", "", "\n", "").Replace(hdr.Value)
+			res.Header.Add(name, value)
+		}
+	}
+	return &res, nil
+}
+
+// findUpload discover upload service of server, UploadService of
+// Config used if set
+func (w *Jabot) findUpload() (*uploadService, error) {
+	w.mu.Lock()
+	svc := w.upload
+	w.mu.Unlock()
+	if svc != nil {
+		return svc, nil
+	}
+	var jids []string
+	if w.cfg.UploadService != "" {
+		jids = []string{w.cfg.UploadService}
+	} else {
+		items, err := w.QueryDiscoItems(w.cfg.Domain)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			jids = append(jids, item.Jid)
+		}
+	}
+	for _, jid := range jids {
+		info, err := w.QueryDiscoInfo(jid, "")
+		if err != nil || !info.HasFeature(nsUpload) {
+			continue
+		}
+		svc = &uploadService{jid: jid}
+		if form := info.Form(nsUpload); form != nil {
+			svc.maxSize, _ = strconv.ParseInt(form.Value("max-file-size"),
+				10, 64)
+		}
+		log.Info("HTTP upload service", jid, "max size", svc.maxSize)
+		w.mu.Lock()
+		w.upload = svc
+		w.mu.Unlock()
+		return svc, nil
+	}
+	return nil, errNoUpload
+}
+
+// RequestSlot
+//	request XEP-0363 upload slot for file of size bytes
+func (w *Jabot) RequestSlot(name string, size int64,
+	mimeType string) (*UploadSlot, error) {
+	svc, err := w.findUpload()
+	if err != nil {
+		return nil, err
+	}
+	if svc.maxSize > 0 && size > svc.maxSize {
+		return nil, errFileTooLarge
+	}
+	iq, err := w.requestIQ(svc.jid, "get",
+		uploadRequestXML(name, size, mimeType))
+	if err != nil {
+		return nil, err
+	}
+	return parseSlot(iq.Query)
+}
+
+// putSlot PUT size bytes of r to upload slot
+func putSlot(slot *UploadSlot, r io.Reader, size int64,
+	mimeType string) error {
+	req, err := http.NewRequest("PUT", slot.PutURL, r)
+	if err != nil {
+		return err
+	}
+	for name, values := range slot.Header {
+		req.Header[name] = values
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", mimeType)
+	resp, err := uploadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusCreated {
+		return errors.New("HTTP upload: " + resp.Status)
+	}
+	return nil
+}
+
+// UploadFile
+//	upload size bytes of r by HTTP upload, returns the URL to share.
+//	must not be called from handlers run by Dail
+func (w *Jabot) UploadFile(name string, r io.Reader, size int64,
+	mimeType string) (string, error) {
+	slot, err := w.RequestSlot(name, size, mimeType)
+	if err != nil {
+		return "", err
+	}
+	if err := putSlot(slot, r, size, mimeType); err != nil {
+		return "", err
+	}
+	return slot.GetURL, nil
+}
+
+func oobXML(url, desc string) string {
+	res := "<x xmlns='" + nsOOB + "'><url>" + xmlEscape(url) + "</url>"
+	if desc != "" {
+		res += "<desc>" + xmlEscape(desc) + "</desc>"
+	}
+	return res + "</x>"
+}
+
+// SendURL
+//	send URL as XEP-0066 out of band data, clients show uploaded
+//	images inline
+func (w *Jabot) SendURL(to, url, desc string) (string, error) {
+	return w.sendURL("", to, url, desc)
+}
+
+// sendURL send URL message with id, generated if empty
+func (w *Jabot) sendURL(id, to, url, desc string) (string, error) {
+	return w.post(&OutMessage{To: to, Type: "chat", ID: id, Body: url,
+		Ext: []string{oobXML(url, desc)}})
+}
+
+// SendFile
+//	upload file of path and send the URL to, MIME type guessed by
+//	extension if empty
+func (w *Jabot) SendFile(to, path, mimeType string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(path))
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	url, err := w.UploadFile(filepath.Base(path), f, st.Size(), mimeType)
+	if err != nil {
+		return "", err
+	}
+	return w.SendURL(to, url, "")
+}
+
+// SendReader
+//	upload content of r as file name and send the URL to, MIME type
+//	detected from content if empty
+func (w *Jabot) SendReader(to, name string, r io.Reader,
+	mimeType string) (string, error) {
+	url, err := w.uploadReader(name, r, mimeType)
+	if err != nil {
+		return "", err
+	}
+	return w.SendURL(to, url, "")
+}
+
+// uploadReader upload content of r as file name, returns get URL
+func (w *Jabot) uploadReader(name string, r io.Reader,
+	mimeType string) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	return w.UploadFile(name, bytes.NewReader(data), int64(len(data)),
+		mimeType)
+}
@@ -0,0 +1,67 @@
+package jabot
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadSlot(t *testing.T) {
+	var body, auth, ctype string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter,
+		req *http.Request) {
+		if req.Method != "PUT" {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		data, _ := ioutil.ReadAll(req.Body)
+		body, auth = string(data), req.Header.Get("Authorization")
+		ctype = req.Header.Get("Content-Type")
+		rw.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	oldClient := uploadClient
+	uploadClient = srv.Client()
+	defer func() { uploadClient = oldClient }()
+	slot, err := parseSlot([]byte("<slot xmlns='urn:xmpp:http:upload:0'>" +
+		"<put url='" + srv.URL + "/up/a.txt'>" +
+		"<header name='Authorization'>Basic Zm9v</header>" +
+		"<header name='Host'>evil.example.com</header></put>" +
+		"<get url='" + srv.URL + "/get/a.txt'/></slot>"))
+	if err != nil {
+		t.Fatal("parseSlot", err)
+	}
+	if slot.GetURL != srv.URL+"/get/a.txt" || len(slot.Header) != 1 {
+		t.Error("parseSlot got", slot)
+	}
+	if err := putSlot(slot, strings.NewReader("hello"), 5,
+		"text/plain"); err != nil {
+		t.Fatal("putSlot", err)
+	}
+	if body != "hello" || auth != "Basic Zm9v" || ctype != "text/plain" {
+		t.Error("PUT got", body, auth, ctype)
+	}
+	slot.PutURL = srv.URL + "/missing"
+	srv.Config.Handler = http.NotFoundHandler()
+	if err := putSlot(slot, strings.NewReader("hello"), 5,
+		"text/plain"); err == nil {
+		t.Error("putSlot to 404 succeeded")
+	}
+	if _, err := parseSlot([]byte("<slot xmlns='urn:xmpp:http:upload:0'>" +
+		"<put url='file:///etc/passwd'/><get url='https://a/b'/></slot>")); err != errSlotURL {
+		t.Error("parseSlot file url got", err)
+	}
+	if _, err := parseSlot([]byte("<slot xmlns='urn:xmpp:http:upload:0'>" +
+		"<put url='http://a/b'/><get url='https://a/b'/></slot>")); err != errSlotURL {
+		t.Error("parseSlot http url got", err)
+	}
+}
+
+func TestOOBXML(t *testing.T) {
+	if s := oobXML("https://a/b?c&d", "chart"); s != "<x xmlns='jabber:x:oob'>"+
+		"<url>https://a/b?c&amp;d</url><desc>chart</desc></x>" {
+		t.Error("oobXML got", s)
+	}
+}
@@ -0,0 +1,156 @@
+package jabot
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	nsPubSub      = "http://jabber.org/protocol/pubsub"
+	nsAvatarData  = "urn:xmpp:avatar:data"
+	nsAvatarMeta  = "urn:xmpp:avatar:metadata"
+	nsVCardUpdate = "vcard-temp:x:update"
+)
+
+var errAvatarType = errors.New("avatar must be PNG or JPEG image")
+
+// Profile of jabot published at startup
+type Profile struct {
+	FullName string `yaml:"fullName"`
+	NickName string `yaml:"nickName"`
+	Desc     string `yaml:"desc"`
+	Avatar   string `yaml:"avatar"` // PNG/JPEG file, e.g. gopher.png
+}
+
+func (vc *VCard) xml() string {
+	res := "<vCard xmlns='" + nsVCard + "'>"
+	if vc.FullName != "" {
+		res += "<FN>" + xmlEscape(vc.FullName) + "</FN>"
+	}
+	if vc.NickName != "" {
+		res += "<NICKNAME>" + xmlEscape(vc.NickName) + "</NICKNAME>"
+	}
+	if vc.Desc != "" {
+		res += "<DESC>" + xmlEscape(vc.Desc) + "</DESC>"
+	}
+	if len(vc.Photo) > 0 {
+		res += "<PHOTO><TYPE>" + vc.PhotoType + "</TYPE><BINVAL>" +
+			base64.StdEncoding.EncodeToString(vc.Photo) + "</BINVAL></PHOTO>"
+	}
+	return res + "</vCard>"
+}
+
+// avatarType check avatar is PNG or JPEG, returns MIME type
+func avatarType(data []byte) (string, error) {
+	switch mimeType := http.DetectContentType(data); mimeType {
+	case "image/png", "image/jpeg":
+		return mimeType, nil
+	}
+	return "", errAvatarType
+}
+
+// SetVCard
+//	publish vCard of jabot as vcard-temp, with avatar (Photo) also as
+//	XEP-0084 user avatar and XEP-0153 hash in presence
+func (w *Jabot) SetVCard(card *VCard) error {
+	vc := *card
+	if len(vc.Photo) > 0 {
+		mimeType, err := avatarType(vc.Photo)
+		if err != nil {
+			return err
+		}
+		vc.PhotoType = mimeType
+	}
+	if _, err := w.requestIQ(getJid(w.cfg.Jid), "set", vc.xml()); err != nil {
+		return err
+	}
+	w.nickName = vc.NickName
+	w.updateVCard(getJid(w.cfg.Jid), &vc)
+	if len(vc.Photo) > 0 {
+		if err := w.SetAvatar(vc.Photo); err != nil {
+			// server may not support PEP, vCard avatar still works
+			log.Warning("publish user avatar", err)
+		}
+	}
+	var hash string
+	if len(vc.Photo) > 0 {
+		sum := sha1.Sum(vc.Photo)
+		hash = hex.EncodeToString(sum[:])
+	}
+	w.mu.Lock()
+	w.avatarHash = &hash
+	w.mu.Unlock()
+	return w.sendPresence()
+}
+
+// SetAvatar publish PNG/JPEG avatar as XEP-0084 user avatar
+func (w *Jabot) SetAvatar(data []byte) error {
+	mimeType, err := avatarType(data)
+	if err != nil {
+		return err
+	}
+	imgCfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	sum := sha1.Sum(data)
+	id := hex.EncodeToString(sum[:])
+	to := getJid(w.cfg.Jid)
+	body := "<pubsub xmlns='" + nsPubSub + "'><publish node='" + nsAvatarData +
+		"'><item id='" + id + "'><data xmlns='" + nsAvatarData + "'>" +
+		base64.StdEncoding.EncodeToString(data) +
+		"</data></item></publish></pubsub>"
+	if _, err := w.requestIQ(to, "set", body); err != nil {
+		return err
+	}
+	body = fmt.Sprintf("<pubsub xmlns='%s'><publish node='%s'><item id='%s'>"+
+		"<metadata xmlns='%s'><info bytes='%d' id='%s' height='%d' "+
+		"width='%d' type='%s'/></metadata></item></publish></pubsub>",
+		nsPubSub, nsAvatarMeta, id, nsAvatarMeta, len(data), id,
+		imgCfg.Height, imgCfg.Width, mimeType)
+	_, err = w.requestIQ(to, "set", body)
+	return err
+}
+
+// vcardUpdateXML XEP-0153 presence element, empty if vCard not set
+func (w *Jabot) vcardUpdateXML() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.avatarHash == nil {
+		return ""
+	}
+	if *w.avatarHash == "" {
+		return "<x xmlns='" + nsVCardUpdate + "'><photo/></x>"
+	}
+	return "<x xmlns='" + nsVCardUpdate + "'><photo>" + *w.avatarHash +
+		"</photo></x>"
+}
+
+// publishProfile SetVCard from Config.Profile
+func (w *Jabot) publishProfile() {
+	prof := w.cfg.Profile
+	card := VCard{FullName: prof.FullName, NickName: prof.NickName,
+		Desc: prof.Desc}
+	if prof.Avatar != "" {
+		data, err := ioutil.ReadFile(prof.Avatar)
+		if err != nil {
+			log.Error("read avatar", err)
+			return
+		}
+		card.Photo = data
+	}
+	if err := w.SetVCard(&card); err != nil {
+		log.Error("SetVCard", err)
+		return
+	}
+	log.Info("published vCard of", w.cfg.Jid)
+}
@@ -0,0 +1,207 @@
+package jabot
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"sort"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	nsDiscoInfo  = "http://jabber.org/protocol/disco#info"
+	nsDiscoItems = "http://jabber.org/protocol/disco#items"
+	nsCaps       = "http://jabber.org/protocol/caps"
+	capsNode     = "https://github.com/kjx98/jabot"
+)
+
+// identity of jabot in service discovery
+var (
+	discoCategory = "client"
+	discoType     = "bot"
+	discoName     = "jabot"
+)
+
+type discoQuery struct {
+	Node string `xml:"node,attr"`
+}
+
+// RegisterFeature
+//	advertise feature namespace in disco#info,
+//	e.g. http://jabber.org/protocol/chatstates, presence with the new
+//	caps ver is re-sent if connected
+func (w *Jabot) RegisterFeature(namespace string) {
+	w.mu.Lock()
+	added := !w.features[namespace]
+	w.features[namespace] = true
+	w.mu.Unlock()
+	if added && w.IsConnected() {
+		if err := w.sendPresence(); err != nil {
+			log.Warning("presence for new caps", err)
+		}
+	}
+}
+
+// Features returns sorted feature namespaces of disco#info
+func (w *Jabot) Features() []string {
+	w.mu.Lock()
+	res := make([]string, 0, len(w.features))
+	for k := range w.features {
+		res = append(res, k)
+	}
+	w.mu.Unlock()
+	sort.Strings(res)
+	return res
+}
+
+// capsVer calc XEP-0115 verification string
+func capsVer(category, iType, name string, features []string) string {
+	s := category + "/" + iType + "//" + name + "<"
+	for _, ns := range features {
+		s += ns + "<"
+	}
+	sum := sha1.Sum([]byte(s))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// CapsVer returns XEP-0115 entity capabilities hash of jabot
+func (w *Jabot) CapsVer() string {
+	return capsVer(discoCategory, discoType, discoName, w.Features())
+}
+
+func (w *Jabot) capsXML() string {
+	return "<c xmlns='" + nsCaps + "' hash='sha-1' node='" + capsNode +
+		"' ver='" + w.CapsVer() + "'/>"
+}
+
+func (w *Jabot) iqDiscoInfo(iq *xmpp.IQ) (string, error) {
+	if iq.Type != "get" {
+		return "", ErrBadRequest
+	}
+	var query discoQuery
+	if err := xml.Unmarshal(iq.Query, &query); err != nil {
+		return "", ErrBadRequest
+	}
+	res := "<query xmlns='" + nsDiscoInfo + "'"
+	if query.Node != "" {
+		if cmd, ok := w.adhocCommands()[query.Node]; ok &&
+			w.adhocAllowed(iq.From) {
+			return adhocInfoXML(query.Node, cmd.name), nil
+		}
+		if query.Node != capsNode+"#"+w.CapsVer() {
+			return "", ErrItemNotFound
+		}
+		res += " node='" + xmlEscape(query.Node) + "'"
+	}
+	res += "><identity category='" + discoCategory + "' type='" + discoType +
+		"' name='" + xmlEscape(discoName) + "'/>"
+	for _, ns := range w.Features() {
+		res += "<feature var='" + xmlEscape(ns) + "'/>"
+	}
+	return res + "</query>", nil
+}
+
+func (w *Jabot) iqDiscoItems(iq *xmpp.IQ) (string, error) {
+	if iq.Type != "get" {
+		return "", ErrBadRequest
+	}
+	var query discoQuery
+	if err := xml.Unmarshal(iq.Query, &query); err != nil {
+		return "", ErrBadRequest
+	}
+	if query.Node == nsCommands {
+		jid := iq.To
+		if jid == "" {
+			jid = w.cfg.Jid + "/" + w.resource
+		}
+		commands := w.adhocCommands()
+		if !w.adhocAllowed(iq.From) {
+			commands = nil
+		}
+		return adhocItemsXML(jid, commands), nil
+	}
+	if query.Node != "" {
+		return "", ErrItemNotFound
+	}
+	return "<query xmlns='" + nsDiscoItems + "'/>", nil
+}
+
+// DiscoIdentity identity of disco#info result
+type DiscoIdentity struct {
+	Category string `xml:"category,attr"`
+	Type     string `xml:"type,attr"`
+	Name     string `xml:"name,attr"`
+}
+
+// DiscoInfo result of disco#info query
+type DiscoInfo struct {
+	Identities []DiscoIdentity `xml:"identity"`
+	Feature    []struct {
+		Var string `xml:"var,attr"`
+	} `xml:"feature"`
+	// Forms XEP-0128 extended info, e.g. max-file-size of upload service
+	Forms []DataForm `xml:"jabber:x:data x"`
+}
+
+// DiscoItem item of disco#items result
+type DiscoItem struct {
+	Jid  string `xml:"jid,attr"`
+	Node string `xml:"node,attr"`
+	Name string `xml:"name,attr"`
+}
+
+// HasFeature check feature namespace in disco#info result
+func (di *DiscoInfo) HasFeature(namespace string) bool {
+	for _, f := range di.Feature {
+		if f.Var == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Form returns extended info form with FORM_TYPE, nil if none
+func (di *DiscoInfo) Form(formType string) *DataForm {
+	for i := range di.Forms {
+		if di.Forms[i].Value("FORM_TYPE") == formType {
+			return &di.Forms[i]
+		}
+	}
+	return nil
+}
+
+// QueryDiscoInfo
+//	query disco#info of jid and node, node could be empty
+func (w *Jabot) QueryDiscoInfo(jid, node string) (*DiscoInfo, error) {
+	query := "<query xmlns='" + nsDiscoInfo + "'/>"
+	if node != "" {
+		query = "<query xmlns='" + nsDiscoInfo + "' node='" +
+			xmlEscape(node) + "'/>"
+	}
+	iq, err := w.requestIQ(jid, "get", query)
+	if err != nil {
+		return nil, err
+	}
+	var res DiscoInfo
+	if err := xml.Unmarshal(iq.Query, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// QueryDiscoItems
+//	query disco#items of jid, e.g. services of server
+func (w *Jabot) QueryDiscoItems(jid string) ([]DiscoItem, error) {
+	iq, err := w.requestIQ(jid, "get", "<query xmlns='"+nsDiscoItems+"'/>")
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Items []DiscoItem `xml:"item"`
+	}
+	if err := xml.Unmarshal(iq.Query, &res); err != nil {
+		return nil, err
+	}
+	return res.Items, nil
+}
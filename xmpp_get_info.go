@@ -1,37 +1,189 @@
 package jabot
 
 import (
+	"encoding/xml"
 	"fmt"
+	"runtime"
 	"time"
+
+	"github.com/kjx98/go-xmpp"
 )
 
+const nsTime = "urn:xmpp:time"
+
+// timeNow for entity time, replaced by tests
+var timeNow = time.Now
+
+// SoftwareVersion of XEP-0092 response
+type SoftwareVersion struct {
+	Name    string `xml:"name"`
+	Version string `xml:"version"`
+	OS      string `xml:"os"`
+}
+
+// LastActivity of XEP-0012 response
+type LastActivity struct {
+	Idle   time.Duration
+	Status string
+}
+
+type lastQuery struct {
+	Seconds int    `xml:"seconds,attr"`
+	Status  string `xml:",chardata"`
+}
+
+func versionBody(name, version, osName string) string {
+	res := "<name>" + xmlEscape(name) + "</name><version>" +
+		xmlEscape(version) + "</version>"
+	if osName != "" {
+		res += "<os>" + xmlEscape(osName) + "</os>"
+	}
+	return res
+}
+
+func lastBody(last int) string {
+	return fmt.Sprintf("<query xmlns='jabber:iq:last' seconds='%d'/>", last)
+}
+
+// timeBody XEP-0202 entity time, tzo as TZD of XEP-0082
+func timeBody(tt time.Time) string {
+	return "<time xmlns='" + nsTime + "'><tzo>" + tt.Format("-07:00") +
+		"</tzo><utc>" + tt.UTC().Format("2006-01-02T15:04:05Z") +
+		"</utc></time>"
+}
+
+type entityTime struct {
+	Tzo string `xml:"tzo"`
+	UTC string `xml:"utc"`
+}
+
+// parseTzo parse TZD "Z" or "+hh:mm"/"-hh:mm" to offset in seconds
+func parseTzo(tzo string) (int, error) {
+	if tzo == "Z" {
+		return 0, nil
+	}
+	tt, err := time.Parse("-07:00", tzo)
+	if err != nil {
+		return 0, err
+	}
+	_, offset := tt.Zone()
+	return offset, nil
+}
+
+// parseTime parse <time xmlns='urn:xmpp:time'/> to time in the entity's zone
+func parseTime(data []byte) (time.Time, error) {
+	var it entityTime
+	if err := xml.Unmarshal(data, &it); err != nil {
+		return time.Time{}, err
+	}
+	tt, err := time.Parse(time.RFC3339Nano, it.UTC)
+	if err != nil {
+		return time.Time{}, err
+	}
+	offset, err := parseTzo(it.Tzo)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return tt.In(time.FixedZone("", offset)), nil
+}
+
 func (c *Jabot) RawVersion(from, to, id, version, osName string) error {
-	body := "<name>jabot/go-xmpp</name><version>" + version + "</version><os>" +
-		osName + "</os>"
-	_, err := c.client.RawInformationQuery(from, to, id, "result", "jabber:iq:version",
-		body)
-	return err
+	body := versionBody(c.cfg.Software.Name, version, osName)
+	return c.rawIQ(from, to, id, "result", "<query xmlns='jabber:iq:version'>"+
+		body+"</query>")
 }
 
 func (c *Jabot) RawLast(from, to, id string, last int) error {
-	body := fmt.Sprintf("<query xmlns='jabber:iq:last' "+
-		"seconds='%d'>Working</query>", last)
-	_, err := c.client.RawInformation(from, to, id, "result", body)
-	return err
+	return c.rawIQ(from, to, id, "result", lastBody(last))
 }
 
 func (c *Jabot) RawLastNA(from, to, id string) error {
-	body := fmt.Sprintf("<error type='cancel'><service-unavailable " +
-		"xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/></error>")
-	_, err := c.client.RawInformation(from, to, id, "error", body)
-	return err
+	return c.rawIQ(from, to, id, "error", ErrServiceUnavailable.XML())
 }
 
 func (c *Jabot) RawIQtime(from, to, id string) error {
-	tt := time.Now()
-	zone, _ := tt.Zone()
-	body := fmt.Sprintf("<time xmlns='urn:xmpp:time'>\n<tzo>%s</tzo><utc>%s"+
-		"</utc></time>", zone, tt.UTC().Format("2006-01-02T15:03:04Z"))
-	_, err := c.client.RawInformation(from, to, id, "result", body)
-	return err
+	return c.rawIQ(from, to, id, "result", timeBody(timeNow()))
+}
+
+// QueryTime
+//	query XEP-0202 entity time of jid, returned in the entity's time zone
+func (w *Jabot) QueryTime(jid string) (time.Time, error) {
+	iq, err := w.requestIQ(jid, "get", "<time xmlns='"+nsTime+"'/>")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseTime(iq.Query)
+}
+
+// QueryVersion
+//	query XEP-0092 software version of jid
+func (w *Jabot) QueryVersion(jid string) (*SoftwareVersion, error) {
+	iq, err := w.requestIQ(jid, "get", "<query xmlns='jabber:iq:version'/>")
+	if err != nil {
+		return nil, err
+	}
+	var res SoftwareVersion
+	if err := xml.Unmarshal(iq.Query, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// QueryLastActivity
+//	query XEP-0012 last activity of jid, idle time for full jid,
+//	time since last logout for bare jid or uptime for server
+func (w *Jabot) QueryLastActivity(jid string) (*LastActivity, error) {
+	iq, err := w.requestIQ(jid, "get", "<query xmlns='jabber:iq:last'/>")
+	if err != nil {
+		return nil, err
+	}
+	var it lastQuery
+	if err := xml.Unmarshal(iq.Query, &it); err != nil {
+		return nil, err
+	}
+	return &LastActivity{Idle: time.Duration(it.Seconds) * time.Second,
+		Status: it.Status}, nil
+}
+
+func (w *Jabot) iqVersion(iq *xmpp.IQ) (string, error) {
+	if iq.Type != "get" {
+		return "", ErrBadRequest
+	}
+	var osName string
+	if w.cfg.Software.ShowOS {
+		osName = runtime.GOOS
+	}
+	return "<query xmlns='jabber:iq:version'>" +
+		versionBody(w.cfg.Software.Name, w.cfg.Software.Version, osName) +
+		"</query>", nil
+}
+
+// isContact check whether jid is subscribed to our presence
+func (w *Jabot) isContact(jid string) bool {
+	jid = getJid(jid)
+	if jid == getJid(w.cfg.Jid) {
+		return true
+	}
+	cc, ok := w.getContact(jid)
+	return ok && (cc.Subscription == "both" || cc.Subscription == "from")
+}
+
+func (w *Jabot) iqLast(iq *xmpp.IQ) (string, error) {
+	if iq.Type != "get" {
+		return "", ErrBadRequest
+	}
+	if w.cfg.LastContactsOnly && !w.isContact(iq.From) {
+		return "", ErrForbidden
+	}
+	w.mu.Lock()
+	tt := time.Now().Sub(w.lastAct)
+	w.mu.Unlock()
+	return lastBody(int(tt.Seconds())), nil
+}
+
+func (w *Jabot) iqTime(iq *xmpp.IQ) (string, error) {
+	if iq.Type != "get" {
+		return "", ErrBadRequest
+	}
+	return timeBody(timeNow()), nil
 }
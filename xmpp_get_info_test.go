@@ -0,0 +1,104 @@
+package jabot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestTimeBody(t *testing.T) {
+	cst := time.FixedZone("CST", 8*3600)
+	tt := time.Date(2019, 3, 5, 21, 47, 9, 0, cst)
+	body := timeBody(tt)
+	if body != "<time xmlns='urn:xmpp:time'><tzo>+08:00</tzo>"+
+		"<utc>2019-03-05T13:47:09Z</utc></time>" {
+		t.Error("timeBody CST got", body)
+	}
+	body = timeBody(time.Date(2019, 3, 5, 13, 47, 9, 0, time.UTC))
+	if body != "<time xmlns='urn:xmpp:time'><tzo>+00:00</tzo>"+
+		"<utc>2019-03-05T13:47:09Z</utc></time>" {
+		t.Error("timeBody UTC got", body)
+	}
+	ndt := time.FixedZone("NDT", -(2*3600 + 1800))
+	body = timeBody(time.Date(2019, 3, 5, 11, 17, 9, 0, ndt))
+	if body != "<time xmlns='urn:xmpp:time'><tzo>-02:30</tzo>"+
+		"<utc>2019-03-05T13:47:09Z</utc></time>" {
+		t.Error("timeBody NDT got", body)
+	}
+}
+
+func TestIQTime(t *testing.T) {
+	cst := time.FixedZone("CST", 8*3600)
+	fixed := time.Date(2020, 12, 31, 23, 59, 58, 0, cst)
+	timeNow = func() time.Time { return fixed }
+	defer func() { timeNow = time.Now }()
+	w, _ := NewJabot(&cfg)
+	body, err := w.iqTime(&xmpp.IQ{Type: "get", ID: "t1"})
+	if err != nil {
+		t.Error("iqTime", err)
+		return
+	}
+	tt, err := parseTime([]byte(body))
+	if err != nil {
+		t.Error("parseTime", err)
+		return
+	}
+	if !tt.Equal(fixed) {
+		t.Error("parseTime got", tt, "expect", fixed)
+	}
+	if _, offset := tt.Zone(); offset != 8*3600 {
+		t.Error("parseTime offset", offset)
+	}
+	if _, err := w.iqTime(&xmpp.IQ{Type: "set"}); err != ErrBadRequest {
+		t.Error("iqTime set got", err)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	// example from XEP-0202
+	data := []byte("<time xmlns='urn:xmpp:time'><tzo>-06:00</tzo>" +
+		"<utc>2006-12-19T17:58:35Z</utc></time>")
+	tt, err := parseTime(data)
+	if err != nil {
+		t.Error("parseTime", err)
+		return
+	}
+	if tt.Format(time.RFC3339) != "2006-12-19T11:58:35-06:00" {
+		t.Error("parseTime got", tt.Format(time.RFC3339))
+	}
+	data = []byte("<time xmlns='urn:xmpp:time'><tzo>Z</tzo>" +
+		"<utc>2006-12-19T17:58:35.123Z</utc></time>")
+	if tt, err = parseTime(data); err != nil {
+		t.Error("parseTime Z", err)
+	} else if tt.Nanosecond() != 123000000 {
+		t.Error("parseTime fraction got", tt)
+	}
+	data = []byte("<time xmlns='urn:xmpp:time'><tzo>CST</tzo>" +
+		"<utc>2006-12-19T17:58:35Z</utc></time>")
+	if _, err = parseTime(data); err == nil {
+		t.Error("parseTime accept zone abbreviation")
+	}
+}
+
+func TestIQVersionLast(t *testing.T) {
+	conf := NewConfig("")
+	conf.Software = Software{Name: "bot<x>", Version: "1.2"}
+	conf.LastContactsOnly = true
+	w, _ := NewJabot(&conf)
+	body, err := w.iqVersion(&xmpp.IQ{Type: "get"})
+	if err != nil || body != "<query xmlns='jabber:iq:version'>"+
+		"<name>bot&lt;x&gt;</name><version>1.2</version></query>" {
+		t.Error("iqVersion got", body, err)
+	}
+	w.contacts["friend@localhost"] = Contact{Jid: "friend@localhost",
+		Subscription: "both"}
+	if _, err := w.iqLast(&xmpp.IQ{Type: "get",
+		From: "friend@localhost/phone"}); err != nil {
+		t.Error("iqLast from contact", err)
+	}
+	if _, err := w.iqLast(&xmpp.IQ{Type: "get",
+		From: "stranger@localhost/pc"}); err != ErrForbidden {
+		t.Error("iqLast from stranger got", err)
+	}
+}
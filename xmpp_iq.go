@@ -0,0 +1,323 @@
+package jabot
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+// IQHandlerFunc type
+//	used for RegisterIQHandler, called with every IQ whose first child
+//	matches the registered namespace and local name. For get/set IQ the
+//	returned string is the payload of the result IQ, a non nil error is
+//	sent back as type error (use *StanzaError for a defined condition).
+//	For result/error IQ the return values are only logged.
+type IQHandlerFunc func(iq *xmpp.IQ) (string, error)
+
+// StanzaError describes an XMPP stanza error (RFC 6120 8.3)
+type StanzaError struct {
+	Type      string // cancel, continue, modify, auth or wait
+	Condition string // defined condition, e.g. service-unavailable
+	Text      string
+}
+
+const (
+	nsStanzas = "urn:ietf:params:xml:ns:xmpp-stanzas"
+	iqTimeout = time.Second * 30
+)
+
+var (
+	ErrBadRequest            = &StanzaError{Type: "modify", Condition: "bad-request"}
+	ErrFeatureNotImplemented = &StanzaError{Type: "cancel", Condition: "feature-not-implemented"}
+	ErrForbidden             = &StanzaError{Type: "auth", Condition: "forbidden"}
+	ErrItemNotFound          = &StanzaError{Type: "cancel", Condition: "item-not-found"}
+	ErrNotAllowed            = &StanzaError{Type: "cancel", Condition: "not-allowed"}
+	ErrServiceUnavailable    = &StanzaError{Type: "cancel", Condition: "service-unavailable"}
+	ErrInternalServerError   = &StanzaError{Type: "cancel", Condition: "internal-server-error"}
+)
+
+var (
+	errIQHandleExist = errors.New("IQ handler already registered")
+	errIQType        = errors.New("IQ type must be get or set")
+	errIQHandlerNil  = errors.New("IQ handler must not be nil")
+	// errIQAsync returned by IQ handler replying later by itself
+	errIQAsync = errors.New("IQ replied asynchronously")
+)
+
+func (e *StanzaError) Error() string {
+	if e.Text != "" {
+		return e.Condition + ": " + e.Text
+	}
+	return e.Condition
+}
+
+// XML returns the <error/> element of the stanza error
+func (e *StanzaError) XML() string {
+	res := "<error type='" + e.Type + "'><" + e.Condition + " xmlns='" +
+		nsStanzas + "'/>"
+	if e.Text != "" {
+		res += "<text xmlns='" + nsStanzas + "'>" + xmlEscape(e.Text) +
+			"</text>"
+	}
+	return res + "</error>"
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func iqKey(namespace, localName string) string {
+	return namespace + " " + localName
+}
+
+// RegisterIQHandler
+//	register handler for IQ with first child <localName xmlns='namespace'/>,
+//	namespace is advertised in disco#info, get/set IQ without handler are
+//	answered with service-unavailable
+func (w *Jabot) RegisterIQHandler(namespace, localName string,
+	iqFunc IQHandlerFunc) error {
+	if err := w.registerIQ(namespace, localName, iqFunc); err != nil {
+		return err
+	}
+	w.RegisterFeature(namespace)
+	return nil
+}
+
+// registerIQ register handler without advertising namespace, for
+// pushes from server like roster and vCard results
+func (w *Jabot) registerIQ(namespace, localName string,
+	iqFunc IQHandlerFunc) error {
+	if iqFunc == nil {
+		return errIQHandlerNil
+	}
+	key := iqKey(namespace, localName)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.iqHandlers[key]; ok {
+		return errIQHandleExist
+	}
+	w.iqHandlers[key] = iqFunc
+	return nil
+}
+
+func (w *Jabot) registerDefaultIQ() {
+	w.iqHandlers = map[string]IQHandlerFunc{}
+	w.features = map[string]bool{}
+	// served to others, advertised
+	w.RegisterIQHandler("jabber:iq:version", "query", w.iqVersion)
+	w.RegisterIQHandler("jabber:iq:last", "query", w.iqLast)
+	w.RegisterIQHandler(nsTime, "time", w.iqTime)
+	w.RegisterIQHandler(nsPing, "ping", w.iqPing)
+	w.RegisterIQHandler(nsDiscoInfo, "query", w.iqDiscoInfo)
+	w.RegisterIQHandler(nsDiscoItems, "query", w.iqDiscoItems)
+	w.RegisterIQHandler(nsCommands, "command", w.iqCommand)
+	// results and pushes from our server only
+	w.registerIQ("jabber:iq:roster", "query", w.iqRoster)
+	w.registerIQ(nsVCard, "vCard", w.iqVCard)
+	// presence and message extensions processed by handle
+	for _, ns := range []string{nsCaps, nsReceipts, nsMarkers,
+		nsChatStates, nsCorrect, nsRetract, nsReactions, nsOOB} {
+		w.RegisterFeature(ns)
+	}
+	if w.cfg.Carbons {
+		w.RegisterFeature(nsCarbons)
+	}
+}
+
+// SendIQError reply iq with type error
+func (w *Jabot) SendIQError(iq *xmpp.IQ, err error) error {
+	se, ok := err.(*StanzaError)
+	if !ok {
+		se = &StanzaError{Type: ErrInternalServerError.Type,
+			Condition: ErrInternalServerError.Condition}
+	}
+	return w.rawIQ(iq.To, iq.From, iq.ID, "error", se.XML())
+}
+
+type stanzaErrorXML struct {
+	Type  string `xml:"type,attr"`
+	Elems []struct {
+		XMLName xml.Name
+		Text    string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+// parseStanzaError extract <error/> from stanza payload, nil if none
+func parseStanzaError(data []byte) *StanzaError {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err != io.EOF {
+				log.Info("parse stanza error:", err)
+			}
+			return nil
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth > 0 || t.Name.Local != "error" {
+				depth++
+				continue
+			}
+			var it stanzaErrorXML
+			if err := dec.DecodeElement(&it, &t); err != nil {
+				log.Info("parse stanza error:", err)
+				return nil
+			}
+			se := &StanzaError{Type: it.Type}
+			for _, elem := range it.Elems {
+				if elem.XMLName.Space != nsStanzas {
+					continue
+				}
+				if elem.XMLName.Local == "text" {
+					se.Text = elem.Text
+				} else {
+					se.Condition = elem.XMLName.Local
+				}
+			}
+			return se
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+func (w *Jabot) nextID(prefix string) string {
+	return prefix + "-" + w.idBase + "-" +
+		strconv.FormatUint(atomic.AddUint64(&w.idSeq, 1), 36)
+}
+
+// SendIQ
+//	send IQ of type get or set with payload body and wait for the result,
+//	IQ of type error returned with *StanzaError
+func (w *Jabot) SendIQ(ctx context.Context, to, iqType, body string) (*xmpp.IQ,
+	error) {
+	if iqType != "get" && iqType != "set" {
+		return nil, errIQType
+	}
+	ch := make(chan *xmpp.IQ, 1)
+	id, err := w.sendIQFunc(to, iqType, body, func(iq *xmpp.IQ) {
+		ch <- iq
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer w.cancelIQ(id)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case iq := <-ch:
+		return iq, iqError(iq)
+	}
+}
+
+// iqError returns *StanzaError for IQ of type error
+func iqError(iq *xmpp.IQ) error {
+	if iq.Type != "error" {
+		return nil
+	}
+	if se := parseStanzaError(iq.Query); se != nil {
+		return se
+	}
+	return ErrInternalServerError
+}
+
+// sendIQFunc send IQ without waiting, respFunc called by Dail goroutine
+// with the result or error IQ, use iqError to check
+func (w *Jabot) sendIQFunc(to, iqType, body string,
+	respFunc func(iq *xmpp.IQ)) (string, error) {
+	if !w.IsConnected() {
+		return "", errNoConn
+	}
+	id := w.nextID(iqType)
+	w.mu.Lock()
+	w.pending[id] = respFunc
+	w.mu.Unlock()
+	if err := w.rawIQ(w.cfg.Jid, to, id, iqType, body); err != nil {
+		w.cancelIQ(id)
+		return "", err
+	}
+	return id, nil
+}
+
+func (w *Jabot) cancelIQ(id string) {
+	w.mu.Lock()
+	delete(w.pending, id)
+	w.mu.Unlock()
+}
+
+// requestIQ SendIQ with default timeout
+func (w *Jabot) requestIQ(to, iqType, body string) (*xmpp.IQ, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), iqTimeout)
+	defer cancel()
+	return w.SendIQ(ctx, to, iqType, body)
+}
+
+// deliverIQ pass result/error IQ to waiting SendIQ
+func (w *Jabot) deliverIQ(iq *xmpp.IQ) bool {
+	w.mu.Lock()
+	respFunc, ok := w.pending[iq.ID]
+	if ok {
+		delete(w.pending, iq.ID)
+	}
+	w.mu.Unlock()
+	if ok {
+		respFunc(iq)
+	}
+	return ok
+}
+
+func (w *Jabot) handleIQ(iq *xmpp.IQ) error {
+	if (iq.Type == "result" || iq.Type == "error") && w.deliverIQ(iq) {
+		return nil
+	}
+	var query xml.Name
+	if len(iq.Query) > 0 {
+		if err := xml.Unmarshal(iq.Query, &query); err != nil {
+			log.Warning("xml.Unmarshal IQ", err)
+			if iq.Type == "get" || iq.Type == "set" {
+				return w.SendIQError(iq, ErrBadRequest)
+			}
+			return nil
+		}
+	}
+	w.mu.Lock()
+	iqFunc, ok := w.iqHandlers[iqKey(query.Space, query.Local)]
+	w.mu.Unlock()
+	if !ok {
+		switch iq.Type {
+		case "get", "set":
+			log.Infof("IQ %s from %s unhandled, tag: (%v)", iq.Type, iq.From,
+				query)
+			return w.SendIQError(iq, ErrServiceUnavailable)
+		}
+		log.Infof("Got from %s to %s IQ, tag: (%v), query(%s)\n",
+			iq.From, iq.To, query, string(iq.Query))
+		return nil
+	}
+	body, err := iqFunc(iq)
+	if err == errIQAsync {
+		// replied by the handler later
+		return nil
+	}
+	if iq.Type != "get" && iq.Type != "set" {
+		if err != nil {
+			log.Info("IQ", iq.Type, " with:", string(iq.Query), err)
+		}
+		return nil
+	}
+	if err != nil {
+		return w.SendIQError(iq, err)
+	}
+	return w.rawIQ(iq.To, iq.From, iq.ID, "result", body)
+}
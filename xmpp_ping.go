@@ -0,0 +1,85 @@
+package jabot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const (
+	nsPing        = "urn:xmpp:ping"
+	pingMaxMissed = 2
+)
+
+var errPongLost = errors.New("No pong from server, connection lost")
+
+func (w *Jabot) iqPing(iq *xmpp.IQ) (string, error) {
+	if iq.Type != "get" {
+		return "", ErrBadRequest
+	}
+	return "", nil
+}
+
+// PingRTT
+//	send XEP-0199 ping to server and wait for the pong,
+//	returns the round trip time
+func (w *Jabot) PingRTT() (time.Duration, error) {
+	timeout := time.Duration(w.cfg.PingTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Second * 30
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	st := time.Now()
+	_, err := w.SendIQ(ctx, w.cfg.Domain, "get", "<ping xmlns='"+nsPing+"'/>")
+	if _, ok := err.(*StanzaError); err != nil && !ok {
+		return 0, err
+	}
+	// error reply means server does not support ping, it is a pong anyway
+	rtt := time.Now().Sub(st)
+	w.mu.Lock()
+	w.rtt = rtt
+	w.mu.Unlock()
+	return rtt, nil
+}
+
+// RTT returns round trip time of last ping
+func (w *Jabot) RTT() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rtt
+}
+
+// keepAlive ping server every PingInterval seconds, close connection
+// when pongs stop arriving so that Dail can reconnect
+func (w *Jabot) keepAlive(client *xmpp.Client, done <-chan struct{}) {
+	interval := time.Duration(w.cfg.PingInterval) * time.Second
+	if interval <= 0 {
+		return
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	missed := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+		}
+		rtt, err := w.PingRTT()
+		if err == nil {
+			missed = 0
+			log.Debug("keepalive ping rtt:", rtt)
+			continue
+		}
+		missed++
+		log.Warning("keepalive ping", err)
+		if missed >= pingMaxMissed {
+			log.Error(errPongLost)
+			w.dropConn(client)
+			return
+		}
+	}
+}
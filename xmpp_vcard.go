@@ -0,0 +1,188 @@
+package jabot
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+const nsVCard = "vcard-temp"
+
+// VCard of XEP-0054 vcard-temp
+type VCard struct {
+	FullName  string
+	NickName  string
+	Desc      string
+	PhotoType string // MIME type of Photo
+	Photo     []byte
+}
+
+type vcardTemp struct {
+	Name      string `xml:"FN"`
+	NickName  string `xml:"NICKNAME"`
+	Desc      string `xml:"DESC"`
+	PhotoType string `xml:"PHOTO>TYPE"`
+	PhotoImg  []byte `xml:"PHOTO>BINVAL"`
+}
+
+type vcardEntry struct {
+	card *VCard
+	at   time.Time
+}
+
+// parseVCard parse <vCard xmlns='vcard-temp'/>, empty data for no vCard
+func parseVCard(data []byte) (*VCard, error) {
+	var it vcardTemp
+	if len(data) > 0 {
+		if err := xml.Unmarshal(data, &it); err != nil {
+			return nil, err
+		}
+	}
+	card := VCard{FullName: it.Name, NickName: it.NickName, Desc: it.Desc}
+	// BINVAL may be folded with white spaces
+	b64 := strings.Join(strings.Fields(string(it.PhotoImg)), "")
+	if b64 != "" {
+		pImg, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, err
+		}
+		card.Photo = pImg
+		card.PhotoType = it.PhotoType
+	}
+	return &card, nil
+}
+
+// AvatarFileName
+//	name of avatar file for jid, keyed by sha1 hash of bare jid
+func AvatarFileName(jid, mimeType string) string {
+	sum := sha1.Sum([]byte(getJid(jid)))
+	ext := ".img"
+	switch mimeType {
+	case "image/png":
+		ext = ".png"
+	case "image/jpeg":
+		ext = ".jpg"
+	case "image/gif":
+		ext = ".gif"
+	case "image/webp":
+		ext = ".webp"
+	}
+	return hex.EncodeToString(sum[:]) + ext
+}
+
+func (w *Jabot) vcardTTL() time.Duration {
+	return time.Duration(w.cfg.VCardTTL) * time.Second
+}
+
+// cachedVCard returns vCard of jid if not expired
+func (w *Jabot) cachedVCard(jid string) *VCard {
+	w.cmu.RLock()
+	defer w.cmu.RUnlock()
+	if ent, ok := w.vcards[jid]; ok && ent.card != nil &&
+		time.Now().Sub(ent.at) < w.vcardTTL() {
+		return ent.card
+	}
+	return nil
+}
+
+// updateVCard cache vCard and update contact of jid
+func (w *Jabot) updateVCard(jid string, card *VCard) {
+	w.cmu.Lock()
+	w.vcards[jid] = vcardEntry{card: card, at: time.Now()}
+	w.cmu.Unlock()
+	if jid == getJid(w.cfg.Jid) {
+		w.nickName = card.NickName
+		log.Info("Got nickName of myself:", card.NickName)
+	}
+	cc, _ := w.getContact(jid)
+	if cc.Name == "" || cc.Jid == "" {
+		cc.Jid = jid
+		cc.Name = card.FullName
+	}
+	if nicN := card.NickName; nicN != "" {
+		cc.NickName = nicN
+	} else {
+		cc.NickName = nickName(jid)
+	}
+	cc.Avatar = card.Photo
+	cc.AvatarType = card.PhotoType
+	w.updateContacts(&cc)
+	if w.cfg.AvatarDir != "" && len(card.Photo) > 0 {
+		fileName := filepath.Join(w.cfg.AvatarDir,
+			AvatarFileName(jid, card.PhotoType))
+		if err := ioutil.WriteFile(fileName, card.Photo, 0644); err != nil {
+			log.Warning("save avatar", err)
+		}
+	}
+	log.Infof("Got vCard for %s, FN:%s, Nick:%s/%s",
+		jid, card.FullName, card.NickName, cc.NickName)
+}
+
+// GetVCard
+//	get vCard of jid from cache or query, must not be called from handlers
+//	run by Dail as the response is received there
+func (w *Jabot) GetVCard(jid string) (*VCard, error) {
+	jid = getJid(jid)
+	if card := w.cachedVCard(jid); card != nil {
+		return card, nil
+	}
+	iq, err := w.requestIQ(jid, "get", "<vCard xmlns='"+nsVCard+"'/>")
+	if err != nil {
+		return nil, err
+	}
+	card, err := parseVCard(iq.Query)
+	if err != nil {
+		return nil, err
+	}
+	w.updateVCard(jid, card)
+	return card, nil
+}
+
+// refreshVCard query vCard of jid in background if cache expired
+func (w *Jabot) refreshVCard(jid string) {
+	w.cmu.Lock()
+	ent, ok := w.vcards[jid]
+	if ok && time.Now().Sub(ent.at) < w.vcardTTL() {
+		w.cmu.Unlock()
+		return
+	}
+	// mark as queried, avoid query again before response
+	ent.at = time.Now()
+	w.vcards[jid] = ent
+	w.cmu.Unlock()
+	_, err := w.sendIQFunc(jid, "get", "<vCard xmlns='"+nsVCard+"'/>",
+		func(iq *xmpp.IQ) {
+			if err := iqError(iq); err != nil {
+				log.Info("vCard of", jid, err)
+				return
+			}
+			if card, err := parseVCard(iq.Query); err != nil {
+				log.Error("vcard-temp vCard", err)
+			} else {
+				w.updateVCard(jid, card)
+			}
+		})
+	if err != nil {
+		log.Warning("query vCard", err)
+	}
+}
+
+func (w *Jabot) iqVCard(iq *xmpp.IQ) (string, error) {
+	if iq.Type == "get" || iq.Type == "set" {
+		// vCard of account served by server
+		return "", ErrServiceUnavailable
+	}
+	// results of our queries are delivered by pending id, others are
+	// unsolicited and dropped, never cache them or save their avatars
+	if iq.Type == "result" {
+		log.Info("drop unrequested vCard from", iq.From, "id", iq.ID)
+	}
+	return "", nil
+}
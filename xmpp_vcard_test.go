@@ -0,0 +1,124 @@
+package jabot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/kjx98/go-xmpp"
+)
+
+func TestParseVCard(t *testing.T) {
+	data := []byte("<vCard xmlns='vcard-temp'><FN>Gopher Bot</FN>" +
+		"<NICKNAME>gopher</NICKNAME><PHOTO><TYPE>image/png</TYPE>" +
+		"<BINVAL>iVBO\n Rw0K</BINVAL></PHOTO></vCard>")
+	card, err := parseVCard(data)
+	if err != nil {
+		t.Error("parseVCard", err)
+		return
+	}
+	if card.FullName != "Gopher Bot" || card.NickName != "gopher" ||
+		card.PhotoType != "image/png" ||
+		!bytes.Equal(card.Photo, []byte("\x89PNG\r\n")) {
+		t.Error("parseVCard got", card)
+	}
+	if card, err = parseVCard(nil); err != nil || card.FullName != "" {
+		t.Error("parseVCard empty", card, err)
+	}
+}
+
+func TestVCardCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jabot")
+	if err != nil {
+		t.Error("TempDir", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	conf := NewConfig("")
+	conf.AvatarDir = dir
+	w, _ := NewJabot(&conf)
+	jid := "friend@localhost"
+	if w.cachedVCard(jid) != nil {
+		t.Error("cachedVCard before update")
+	}
+	w.updateVCard(jid, &VCard{FullName: "Friend", NickName: "fr",
+		PhotoType: "image/png", Photo: []byte("png")})
+	if card := w.cachedVCard(jid); card == nil || card.NickName != "fr" {
+		t.Error("cachedVCard got", card)
+	}
+	if cc, ok := w.getContact(jid); !ok || cc.AvatarType != "image/png" ||
+		string(cc.Avatar) != "png" {
+		t.Error("contact avatar", cc)
+	}
+	name := AvatarFileName(jid+"/phone", "image/png")
+	if name != "f36131e31142dd809e5d29e9de47fc72f7abad50.png" {
+		t.Error("AvatarFileName got", name)
+	}
+	if buf, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil ||
+		string(buf) != "png" {
+		t.Error("avatar file", err)
+	}
+	w.cfg.VCardTTL = 0
+	if w.cachedVCard(jid) != nil {
+		t.Error("cachedVCard not expired")
+	}
+}
+
+func TestVCardXML(t *testing.T) {
+	img, err := ioutil.ReadFile("gopher.png")
+	if err != nil {
+		t.Error("read gopher.png", err)
+		return
+	}
+	if mimeType, err := avatarType(img); err != nil || mimeType != "image/png" {
+		t.Error("avatarType gopher.png", mimeType, err)
+	}
+	if _, err := avatarType([]byte("GIF89a")); err != errAvatarType {
+		t.Error("avatarType GIF got", err)
+	}
+	vc := VCard{FullName: "Gopher & Bot", NickName: "gopher", Desc: "jabber bot",
+		PhotoType: "image/png", Photo: img}
+	card, err := parseVCard([]byte(vc.xml()))
+	if err != nil {
+		t.Error("parseVCard", err)
+		return
+	}
+	if card.FullName != vc.FullName || card.Desc != vc.Desc ||
+		!bytes.Equal(card.Photo, img) {
+		t.Error("VCard xml round trip got", card.FullName, card.Desc)
+	}
+}
+
+func TestUnrequestedVCard(t *testing.T) {
+	w, _ := NewJabot(&cfg)
+	sl, restore := captureStanzas(w)
+	defer restore()
+	result := func(id string) *xmpp.IQ {
+		return &xmpp.IQ{ID: id, From: "bob@localhost", To: cfg.Jid,
+			Type:  "result",
+			Query: []byte("<vCard xmlns='vcard-temp'><FN>Bob</FN></vCard>")}
+	}
+	if err := w.handleIQ(result("forged")); err != nil {
+		t.Error("handleIQ", err)
+	}
+	if card := w.cachedVCard("bob@localhost"); card != nil {
+		t.Error("unrequested vCard cached", card)
+	}
+	w.refreshVCard("bob@localhost")
+	stanzas := sl.all()
+	if len(stanzas) != 1 {
+		t.Fatal("vCard query got", stanzas)
+	}
+	id := regexp.MustCompile(`id='([^']+)'`).FindStringSubmatch(stanzas[0])
+	if id == nil {
+		t.Fatal("vCard query without id", stanzas[0])
+	}
+	w.handleIQ(result(id[1]))
+	if card := w.cachedVCard("bob@localhost"); card == nil ||
+		card.FullName != "Bob" {
+		t.Error("requested vCard got", card)
+	}
+}